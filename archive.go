@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveEntry 已完成下载的视频，按 site+id 或规范化后的页面地址去重
+type ArchiveEntry struct {
+	Site   string    `json:"site"`
+	ID     string    `json:"id"` // 站点规则提取的番号，没有时为空，只按页面地址去重
+	URL    string    `json:"url"`
+	Source string    `json:"source,omitempty"` // 提交的地址，与页面地址不同时记录（例如被改写的地址、m3u8;title）
+	Title  string    `json:"title,omitempty"`
+	Path   string    `json:"path,omitempty"` // 下载时输出文件的绝对路径，文件移动后不影响去重
+	Time   time.Time `json:"time"`
+}
+
+// urls 返回页面地址和提交的地址
+func (e *ArchiveEntry) urls() []string {
+	var urls []string
+	for _, u := range []string{e.URL, e.Source} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (e *ArchiveEntry) key() string {
+	if e.Site == "" || e.ID == "" {
+		return ""
+	}
+	return e.Site + " " + strings.ToLower(e.ID)
+}
+
+// DownloadArchive 下载记录文件，每行一条 JSON，跨多次运行跳过已下载的视频
+type DownloadArchive struct {
+	path    string
+	mu      sync.Mutex
+	entries []*ArchiveEntry
+	byKey   map[string]*ArchiveEntry
+	byURL   map[string]*ArchiveEntry
+
+	siteOf func(rawURL string) string
+}
+
+// OpenDownloadArchive 文件不存在时创建空的记录
+func OpenDownloadArchive(path string) (*DownloadArchive, error) {
+	da := &DownloadArchive{path: path, siteOf: siteName}
+	da.reset(nil)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return da, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := readArchiveEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("read archive %s: %w", path, err)
+	}
+	da.reset(entries)
+	return da, nil
+}
+
+// SetSiteOf 指定页面地址所属的站点，用于把镜像域名视为同一个站点，默认为去掉 www. 的域名
+func (da *DownloadArchive) SetSiteOf(fn func(rawURL string) string) {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	da.siteOf = fn
+	da.reset(da.entries)
+}
+
+func (da *DownloadArchive) reset(entries []*ArchiveEntry) {
+	da.entries = entries[:0:0]
+	da.byKey = make(map[string]*ArchiveEntry)
+	da.byURL = make(map[string]*ArchiveEntry)
+	for _, e := range entries {
+		da.index(e)
+	}
+}
+
+func (da *DownloadArchive) index(e *ArchiveEntry) {
+	da.entries = append(da.entries, e)
+	if k := e.key(); k != "" {
+		da.byKey[k] = e
+	}
+	for _, u := range e.urls() {
+		da.byURL[da.canonical(u)] = e
+	}
+}
+
+// readArchiveEntries 每行可以是 JSON、yt-dlp 格式的 "site id" 或页面地址
+func readArchiveEntries(r io.Reader) ([]*ArchiveEntry, error) {
+	var entries []*ArchiveEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "{"):
+			e := &ArchiveEntry{}
+			if err := json.Unmarshal([]byte(line), e); err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case strings.HasPrefix(line, "http"):
+			entries = append(entries, &ArchiveEntry{URL: line})
+		default:
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid archive line: %s", line)
+			}
+			entries = append(entries, &ArchiveEntry{Site: strings.ToLower(fields[0]), ID: fields[1]})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// HasURL 下载前检查，避免为已下载的视频启动浏览器
+func (da *DownloadArchive) HasURL(rawURL string) bool {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	_, ok := da.byURL[da.canonical(rawURL)]
+	return ok
+}
+
+// Has 获取元数据后按 site+番号检查，同一个视频的不同地址（镜像、语言版本）也能去重
+func (da *DownloadArchive) Has(meta *VideoMeta) bool {
+	e := da.newEntry(meta, "")
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	if k := e.key(); k != "" {
+		if _, ok := da.byKey[k]; ok {
+			return true
+		}
+	}
+	_, ok := da.byURL[da.canonical(meta.URL)]
+	return ok
+}
+
+// SameVideoAt 按输出文件查找最新的下载记录，found 为 false 表示没有该文件的记录
+func (da *DownloadArchive) SameVideoAt(file string, meta *VideoMeta) (same, found bool) {
+	want := da.newEntry(meta, "")
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false, false
+	}
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	for i := len(da.entries) - 1; i >= 0; i-- {
+		e := da.entries[i]
+		if e.Path == "" {
+			continue
+		}
+		if p, err := filepath.Abs(e.Path); err != nil || p != abs {
+			continue
+		}
+		same = (e.key() != "" && e.key() == want.key()) || (e.URL != "" && da.canonical(e.URL) == da.canonical(meta.URL))
+		return same, true
+	}
+	return false, false
+}
+
+func (da *DownloadArchive) newEntry(meta *VideoMeta, path string) *ArchiveEntry {
+	id := ""
+	if meta.SiteCode {
+		id = meta.Code
+	}
+	return &ArchiveEntry{
+		Site:  da.siteOf(meta.URL),
+		ID:    id,
+		URL:   meta.URL,
+		Title: meta.Title,
+		Path:  path,
+		Time:  time.Now(),
+	}
+}
+
+// Add 记录下载完成的视频，立即追加到文件。sourceURL 为提交的地址，规范化后与页面地址不同时一并记录；
+// path 保存为绝对路径，prune -missing 不受工作目录影响
+func (da *DownloadArchive) Add(meta *VideoMeta, sourceURL, path string) error {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	e := da.newEntry(meta, path)
+	if sourceURL != "" && da.canonical(sourceURL) != da.canonical(meta.URL) {
+		e.Source = sourceURL
+	}
+	return da.append([]*ArchiveEntry{e})
+}
+
+func (da *DownloadArchive) append(entries []*ArchiveEntry) error {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	if dir := filepath.Dir(da.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(da.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		da.index(e)
+	}
+	return nil
+}
+
+// Import 导入其他记录文件或 URL 列表，返回新增的数量
+func (da *DownloadArchive) Import(r io.Reader) (int, error) {
+	entries, err := readArchiveEntries(r)
+	if err != nil {
+		return 0, err
+	}
+	var added []*ArchiveEntry
+	da.mu.Lock()
+	for _, e := range entries {
+		if k := e.key(); k != "" && da.byKey[k] != nil {
+			continue
+		}
+		if slices.ContainsFunc(e.urls(), func(u string) bool { return da.byURL[da.canonical(u)] != nil }) {
+			continue
+		}
+		if e.Site == "" && e.URL != "" {
+			e.Site = da.siteOf(e.URL)
+		}
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		added = append(added, e)
+	}
+	da.mu.Unlock()
+	return len(added), da.append(added)
+}
+
+// Entries 按下载时间排序
+func (da *DownloadArchive) Entries() []*ArchiveEntry {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	entries := append([]*ArchiveEntry(nil), da.entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries
+}
+
+// Prune 删除 remove 返回 true 的记录并重写文件，返回删除的数量
+func (da *DownloadArchive) Prune(remove func(e *ArchiveEntry) bool) (int, error) {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	var kept []*ArchiveEntry
+	for _, e := range da.entries {
+		if !remove(e) {
+			kept = append(kept, e)
+		}
+	}
+	removed := len(da.entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	// 先写临时文件再替换，避免中途退出损坏记录
+	tmp := da.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range kept {
+		data, err := json.Marshal(e)
+		if err != nil {
+			_ = f.Close()
+			return 0, err
+		}
+		_, _ = w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, da.path); err != nil {
+		return 0, err
+	}
+	da.reset(kept)
+	return removed, nil
+}
+
+func (da *DownloadArchive) canonical(rawURL string) string {
+	return canonicalVideoURL(rawURL, da.siteOf)
+}
+
+// canonicalVideoURL 规范化页面地址：域名替换为站点，忽略 http/https、fragment、结尾的 / 和 utm_ 参数
+func canonicalVideoURL(rawURL string, siteOf func(rawURL string) string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = "https"
+	u.Host = siteOf(rawURL)
+	u.Fragment = ""
+	u.Path = strings.TrimRight(u.Path, "/")
+	q := u.Query()
+	for k := range q {
+		if strings.HasPrefix(k, "utm_") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// runArchiveCommand 处理 archive list|import|prune 子命令
+func runArchiveCommand(da *DownloadArchive, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: archive list|import <file>...|prune [options] [url|site id ...]")
+	}
+	switch args[0] {
+	case "list":
+		for _, e := range da.Entries() {
+			fmt.Fprintf(w, "%s  %-16s %-20s %s  %s\n", e.Time.Format("2006-01-02 15:04"), e.Site, e.ID, e.URL, e.Title)
+		}
+		return nil
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: archive import <file>...")
+		}
+		for _, path := range args[1:] {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			n, err := da.Import(f)
+			_ = f.Close()
+			if err != nil {
+				return fmt.Errorf("import %s: %w", path, err)
+			}
+			fmt.Fprintf(w, "Imported %d entries from %s\n", n, path)
+		}
+		return nil
+	case "prune":
+		fs := flag.NewFlagSet("archive prune", flag.ContinueOnError)
+		olderThan := fs.Duration("older-than", 0, "remove entries downloaded before this duration, e.g. 720h")
+		missing := fs.Bool("missing", false, "remove entries whose output file no longer exists")
+		site := fs.String("site", "", "remove all entries of this site")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		// 剩余参数为页面地址或 "site id"
+		urls := make(map[string]bool)
+		keys := make(map[string]bool)
+		rest := fs.Args()
+		for i := 0; i < len(rest); i++ {
+			if strings.HasPrefix(rest[i], "http") {
+				urls[da.canonical(rest[i])] = true
+			} else if i+1 < len(rest) {
+				keys[(&ArchiveEntry{Site: strings.ToLower(rest[i]), ID: rest[i+1]}).key()] = true
+				i++
+			} else {
+				return fmt.Errorf("expected url or \"site id\", got %q", rest[i])
+			}
+		}
+		if *olderThan == 0 && !*missing && *site == "" && len(urls) == 0 && len(keys) == 0 {
+			return fmt.Errorf("archive prune: nothing to prune, specify -older-than, -missing, -site or entries")
+		}
+		deadline := time.Now().Add(-*olderThan)
+		n, err := da.Prune(func(e *ArchiveEntry) bool {
+			if *olderThan > 0 && e.Time.Before(deadline) {
+				return true
+			}
+			if *missing && e.Path != "" {
+				if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+					return true
+				}
+			}
+			if *site != "" && strings.EqualFold(e.Site, *site) {
+				return true
+			}
+			if slices.ContainsFunc(e.urls(), func(u string) bool { return urls[da.canonical(u)] }) {
+				return true
+			}
+			return e.key() != "" && keys[e.key()]
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Pruned %d entries\n", n)
+		return nil
+	}
+	return fmt.Errorf("unknown archive command %q", args[0])
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// 浏览器请求头中不需要回放的字段：由 http.Transport 自行处理或通过 cookie jar 单独回放
+var skipReplayHeaders = map[string]bool{
+	"Host":              true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Accept-Encoding":   true,
+	"Cookie":            true,
+	"Range":             true,
+	"Upgrade":           true,
+	"Transfer-Encoding": true,
+}
+
+// PlaylistKind playlist 类型
+type PlaylistKind string
+
+const (
+	PlaylistUnknown PlaylistKind = "unknown" // 无法读取响应内容
+	PlaylistMaster  PlaylistKind = "master"  // HLS 多码率 playlist
+	PlaylistMedia   PlaylistKind = "media"   // HLS 分片 playlist
+	PlaylistDash    PlaylistKind = "dash"    // DASH mpd
+	PlaylistInvalid PlaylistKind = "invalid" // 响应不是 playlist（广告、404 页面等）
+)
+
+// Playable 下载器可以直接处理的 playlist
+func (k PlaylistKind) Playable() bool {
+	return k == PlaylistMaster || k == PlaylistMedia || k == PlaylistUnknown
+}
+
+// PlaylistCandidate 页面会话中出现过的 m3u8/mpd 请求
+type PlaylistCandidate struct {
+	URL     string
+	Kind    PlaylistKind
+	Headers http.Header // 浏览器请求该 playlist 时实际发送的请求头
+}
+
+// classifyPlaylist 根据响应内容判断 playlist 类型
+func classifyPlaylist(body string) PlaylistKind {
+	switch {
+	case strings.Contains(body, "#EXT-X-STREAM-INF"):
+		return PlaylistMaster
+	case strings.Contains(body, "#EXTINF"):
+		return PlaylistMedia
+	case strings.Contains(body, "<MPD"):
+		return PlaylistDash
+	}
+	return PlaylistInvalid
+}
+
+func isPlaylistURL(rawURL string) bool {
+	path := strings.ToLower(rawURL)
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	return strings.HasSuffix(path, ".m3u8") || strings.HasSuffix(path, ".mpd") || strings.Contains(rawURL, ".m3u8")
+}
+
+type pendingPlaylist struct {
+	url     string
+	headers network.Headers
+}
+
+// playlistCapture 收集页面会话中所有的 m3u8/mpd 请求，响应加载完成后根据内容分类，
+// 第一个可播放的 playlist 出现时立即通知
+type playlistCapture struct {
+	filter     *regexp.Regexp
+	mu         sync.Mutex
+	pending    map[network.RequestID]*pendingPlaylist
+	extra      map[network.RequestID]network.Headers
+	candidates []PlaylistCandidate
+	playableCh chan PlaylistCandidate
+}
+
+// newPlaylistCapture filter 不为空时只接受 URL 匹配 filter 的请求
+func newPlaylistCapture(filter *regexp.Regexp) *playlistCapture {
+	return &playlistCapture{
+		filter:     filter,
+		pending:    make(map[network.RequestID]*pendingPlaylist),
+		extra:      make(map[network.RequestID]network.Headers),
+		playableCh: make(chan PlaylistCandidate, 1),
+	}
+}
+
+// Listen 需要在 network.Enable() 之后调用
+func (c *playlistCapture) Listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			if c.filter != nil && !c.filter.MatchString(ev.Request.URL) {
+				return
+			}
+			if c.filter == nil && !isPlaylistURL(ev.Request.URL) {
+				return
+			}
+			c.mu.Lock()
+			c.pending[ev.RequestID] = &pendingPlaylist{url: ev.Request.URL, headers: ev.Request.Headers}
+			c.mu.Unlock()
+		case *network.EventRequestWillBeSentExtraInfo:
+			// ExtraInfo 中是实际发出的完整请求头（包含 Cookie、Authorization 等）
+			c.mu.Lock()
+			c.extra[ev.RequestID] = ev.Headers
+			c.mu.Unlock()
+		case *network.EventLoadingFinished:
+			c.mu.Lock()
+			_, ok := c.pending[ev.RequestID]
+			c.mu.Unlock()
+			if ok {
+				// 事件回调中不能同步执行 CDP 命令
+				go c.classify(ctx, ev.RequestID)
+			}
+		case *network.EventLoadingFailed:
+			c.mu.Lock()
+			delete(c.pending, ev.RequestID)
+			c.mu.Unlock()
+		}
+	})
+}
+
+func (c *playlistCapture) classify(ctx context.Context, requestID network.RequestID) {
+	var body []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetResponseBody(requestID).Do(ctx)
+		return err
+	}))
+	kind := PlaylistUnknown
+	if err == nil {
+		kind = classifyPlaylist(string(body))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[requestID]
+	if !ok {
+		return
+	}
+	delete(c.pending, requestID)
+	candidate := PlaylistCandidate{
+		URL:     p.url,
+		Kind:    kind,
+		Headers: replayHeaders(p.headers, c.extra[requestID]),
+	}
+	c.candidates = append(c.candidates, candidate)
+	if kind.Playable() {
+		select {
+		case c.playableCh <- candidate:
+		default:
+		}
+	}
+}
+
+// Playable 第一个可播放的 playlist
+func (c *playlistCapture) Playable() <-chan PlaylistCandidate {
+	return c.playableCh
+}
+
+// Candidates 目前为止收集到的所有 playlist
+func (c *playlistCapture) Candidates() []PlaylistCandidate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]PlaylistCandidate(nil), c.candidates...)
+}
+
+// replayHeaders 合并请求头，去掉 HTTP/2 伪首部和不需要回放的字段
+func replayHeaders(sources ...network.Headers) http.Header {
+	h := http.Header{}
+	for _, src := range sources {
+		for k, v := range src {
+			// HTTP/2 伪首部，例如 :authority、:path
+			if strings.HasPrefix(k, ":") {
+				continue
+			}
+			k = http.CanonicalHeaderKey(k)
+			if skipReplayHeaders[k] {
+				continue
+			}
+			h.Set(k, fmt.Sprint(v))
+		}
+	}
+	return h
+}
+
+// CaptureOptions 浏览器抓取 playlist 时的可选行为
+type CaptureOptions struct {
+	Actions []chromedp.Action // 页面就绪后、等待 playlist 前执行，例如点击播放按钮、关闭遮罩
+	Timeout time.Duration     // 等待可播放 playlist 的最长时间，0 表示 CAPTURE_TIMEOUT
+
+	NetworkFilter *regexp.Regexp // 只接受 URL 匹配该正则的 playlist 请求，用于过滤广告等无关请求
+
+	Details *DetailRule // 封面、演员、标签等信息的提取规则
+}
+
+// ClickIfPresent 点击第一个匹配的元素，元素不存在时忽略
+func ClickIfPresent(selector string) chromedp.Action {
+	return chromedp.Evaluate(fmt.Sprintf(`(() => {
+		const el = document.querySelector(%q);
+		if (el) { el.click(); return true; }
+		return false;
+	})()`, selector), nil)
+}
+
+// RemoveIfPresent 删除所有匹配的元素，用于关闭广告/年龄确认等遮罩
+func RemoveIfPresent(selector string) chromedp.Action {
+	return chromedp.Evaluate(fmt.Sprintf(`document.querySelectorAll(%q).forEach(el => el.remove())`, selector), nil)
+}
+
+// browserCookies 读取浏览器中作用于 targetURL 的 cookie
+func browserCookies(ctx context.Context, targetURL string) ([]*http.Cookie, error) {
+	var cookies []*network.Cookie
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		cookies, err = network.GetCookies().WithURLs([]string{targetURL}).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		hc := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		// 会话 cookie 的 Expires 为 -1
+		if !c.Session && c.Expires > 0 {
+			sec, frac := math.Modf(c.Expires)
+			hc.Expires = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		result = append(result, hc)
+	}
+	return result, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// BROWSER_MAX_TABS 单个 Chrome 实例累计打开的标签页数量达到后重启，避免内存持续增长
+	BROWSER_MAX_TABS = 50
+	// BROWSER_MAX_TAB_HEAP 标签页关闭前 JS 堆超过该值时重启所在实例
+	BROWSER_MAX_TAB_HEAP = 512 * 1024 * 1024
+)
+
+var errBrowserPoolClosed = errors.New("browser pool closed")
+
+// BrowserPool 维护最多 size 个常驻的 Chrome 实例，每次 FetchVideoMeta 分配一个标签页。
+// Chrome 的代理只能在启动时指定，所以实例按代理区分
+type BrowserPool struct {
+	size      int
+	mu        sync.Mutex
+	cond      *sync.Cond
+	instances []*browserInstance
+	closed    bool
+
+	allocOptions []chromedp.ExecAllocatorOption // 新启动实例时附加的 Chrome 参数
+}
+
+type browserInstance struct {
+	proxyKey   string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	activeTabs int
+	tabsServed int
+	retired    bool // 不再分配新标签页，所有标签页释放后关闭
+}
+
+func (bi *browserInstance) alive() bool {
+	return !bi.retired && bi.ctx.Err() == nil
+}
+
+func NewBrowserPool(size int) *BrowserPool {
+	if size < 1 {
+		size = 1
+	}
+	bp := &BrowserPool{size: size}
+	bp.cond = sync.NewCond(&bp.mu)
+	return bp
+}
+
+// Acquire 分配一个标签页，使用完后必须调用 release，broken 为 true 表示标签页或浏览器已异常，
+// 所在实例会被回收
+func (bp *BrowserPool) Acquire(proxyURL *url.URL) (context.Context, func(broken bool), error) {
+	proxyKey := ""
+	if proxyURL != nil {
+		proxyKey = proxyURL.String()
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for {
+		if bp.closed {
+			return nil, nil, errBrowserPoolClosed
+		}
+		bp.reapLocked()
+		inst := bp.pickLocked(proxyKey)
+		if inst != nil {
+			inst.activeTabs++
+		} else if len(bp.instances) < bp.size {
+			var err error
+			if inst, err = bp.startLocked(proxyURL, proxyKey); err != nil {
+				return nil, nil, err
+			}
+		}
+		if inst == nil && bp.evictIdleLocked() {
+			continue
+		}
+		if inst == nil {
+			bp.cond.Wait()
+			continue
+		}
+
+		tabCtx, tabCancel := chromedp.NewContext(inst.ctx)
+		release := func(broken bool) {
+			if !broken && tabHeapTooLarge(tabCtx) {
+				slog.Info("Browser tab memory too large, recycling browser instance")
+				broken = true
+			}
+			tabCancel()
+			bp.release(inst, broken)
+		}
+		return tabCtx, release, nil
+	}
+}
+
+func (bp *BrowserPool) release(inst *browserInstance, broken bool) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	inst.activeTabs--
+	inst.tabsServed++
+	if broken || inst.tabsServed >= BROWSER_MAX_TABS {
+		inst.retired = true
+	}
+	bp.reapLocked()
+	bp.cond.Broadcast()
+}
+
+// pickLocked 选择代理相同、标签页最少的实例；每个实例同时只分配一个标签页，保证抓取互不干扰
+func (bp *BrowserPool) pickLocked(proxyKey string) *browserInstance {
+	for _, inst := range bp.instances {
+		if inst.proxyKey == proxyKey && inst.alive() && inst.activeTabs == 0 {
+			return inst
+		}
+	}
+	return nil
+}
+
+// startLocked 先在池中占位，再释放锁启动 Chrome，启动期间不阻塞其他 Acquire 和 release。
+// 返回的实例已为调用者占用一个标签页
+func (bp *BrowserPool) startLocked(proxyURL *url.URL, proxyKey string) (*browserInstance, error) {
+	ctx, cancel := createContextWithUA(proxyURL, bp.allocOptions...)
+	inst := &browserInstance{proxyKey: proxyKey, ctx: ctx, cancel: cancel, activeTabs: 1}
+	bp.instances = append(bp.instances, inst)
+
+	bp.mu.Unlock()
+	// 第一次 Run 会启动浏览器
+	err := chromedp.Run(ctx)
+	bp.mu.Lock()
+
+	if bp.closed {
+		// 启动期间池已关闭，Close 已经关闭了该实例
+		return nil, errBrowserPoolClosed
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		slog.Error("Failed to start browser", "error", err)
+		inst.activeTabs--
+		inst.retired = true
+		inst.cancel()
+		bp.reapLocked()
+		bp.cond.Broadcast()
+		return nil, err
+	}
+	return inst, nil
+}
+
+// evictIdleLocked 池已满且没有可用实例时，关闭一个空闲实例（通常是代理不同的实例）
+func (bp *BrowserPool) evictIdleLocked() bool {
+	for _, inst := range bp.instances {
+		if inst.activeTabs == 0 {
+			inst.retired = true
+			bp.reapLocked()
+			return true
+		}
+	}
+	return false
+}
+
+// reapLocked 关闭已崩溃或已退役且没有活动标签页的实例
+func (bp *BrowserPool) reapLocked() {
+	kept := bp.instances[:0]
+	for _, inst := range bp.instances {
+		if inst.activeTabs == 0 && !inst.alive() {
+			closeBrowser(inst)
+			continue
+		}
+		kept = append(kept, inst)
+	}
+	bp.instances = kept
+}
+
+// Close 关闭所有浏览器实例，正在使用的标签页会被中断
+func (bp *BrowserPool) Close() {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.closed = true
+	for _, inst := range bp.instances {
+		closeBrowser(inst)
+	}
+	bp.instances = nil
+	bp.cond.Broadcast()
+}
+
+func closeBrowser(inst *browserInstance) {
+	ctx, cancel := context.WithTimeout(inst.ctx, 5*time.Second)
+	defer cancel()
+	_ = chromedp.Cancel(ctx)
+	inst.cancel()
+}
+
+// tabHeapTooLarge 检查标签页的 JS 堆大小，用于发现内存持续增长的浏览器实例
+func tabHeapTooLarge(tabCtx context.Context) bool {
+	if tabCtx.Err() != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(tabCtx, 2*time.Second)
+	defer cancel()
+	var heap float64
+	err := chromedp.Run(ctx, chromedp.Evaluate(`performance.memory ? performance.memory.usedJSHeapSize : 0`, &heap))
+	return err == nil && heap > BROWSER_MAX_TAB_HEAP
+}
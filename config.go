@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// Config 配置文件（JSON），命令行参数优先级高于配置文件
+type Config struct {
+	LimitRate string `json:"limit_rate"` // 全局带宽上限，例如 "2M"，空或 "0" 表示不限速
+	HostConns int    `json:"host_conns"` // 每个 host 的最大并发连接数，0 表示不限制
+	Workers   int    `json:"workers"`    // 所有视频共享的分片下载 worker 总数，默认 DOWNLOAD_WORKERS
+	Jobs      int    `json:"jobs"`       // 同时下载的视频数量，默认 1
+
+	Proxy ProxyConfig `json:"proxy"` // 代理配置，默认使用 HTTP_PROXY/NO_PROXY 环境变量
+
+	Headers     map[string]string `json:"headers"`      // 所有 m3u8/key/ts 请求附加的请求头
+	SiteHeaders []SiteHeaderRule  `json:"site_headers"` // 按站点附加的请求头
+	Cookies     string            `json:"cookies"`      // Netscape 格式的 cookies 文件
+
+	Sites     []SiteDefinition `json:"sites"`      // 站点定义，启动时与内置站点一起注册
+	SitesFile string           `json:"sites_file"` // 单独的站点定义文件
+
+	HostAliases map[string]string `json:"host_aliases"` // 镜像域名 -> 已注册的站点域名
+
+	Sidecar SidecarConfig `json:"sidecar"` // 下载完成后生成 NFO 和海报
+
+	Archive string `json:"archive"`  // 下载记录文件，跳过以前下载过的视频
+	JobsDB  string `json:"jobs_db"`  // 任务状态数据库，中断后重新运行会从中断的位置继续
+	TempDir string `json:"temp_dir"` // 分片临时目录，默认系统临时目录；使用 jobs_db 时默认为 <jobs_db>.parts
+	Listen  string `json:"listen"`   // serve 模式的监听地址，默认 DEFAULT_LISTEN
+
+	Progress string    `json:"progress"` // 进度输出：auto、tty、log、json、none
+	Log      LogConfig `json:"log"`      // 日志级别、格式和日志文件
+
+	OutputTemplate string `json:"output_template"` // 输出路径模板，例如 "{site}/{id} - {title}.{ext}"
+	FilenameMode   string `json:"filename_mode"`   // 文件名规则：portable、windows、posix
+}
+
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyLimits 将限速配置应用到全局限速器，可在运行时重复调用
+func (c *Config) ApplyLimits() error {
+	rate, err := parseByteRate(c.LimitRate)
+	if err != nil {
+		return err
+	}
+	globalRateLimiter.SetRate(rate)
+	globalHostLimiter.SetLimit(c.HostConns)
+	workers := c.Workers
+	if workers <= 0 {
+		workers = DOWNLOAD_WORKERS
+	}
+	globalWorkerBudget.SetCapacity(workers)
+	slog.Info("Limits applied", "rate", rate, "host_conns", c.HostConns, "workers", workers)
+	return nil
+}
+
+// CurrentLimits 当前生效的限速参数
+func CurrentLimits() *Config {
+	return &Config{
+		LimitRate: strconv.FormatInt(globalRateLimiter.Rate(), 10),
+		HostConns: globalHostLimiter.Limit(),
+		Workers:   globalWorkerBudget.Capacity(),
+	}
+}
+
+// RequestOverrides 生成全局请求头/cookie 覆盖，extraHeaders 为命令行传入的 "Name: value"
+func (c *Config) RequestOverrides(extraHeaders []string) (*RequestOverrides, error) {
+	o := &RequestOverrides{
+		Headers:     http.Header{},
+		SiteHeaders: c.SiteHeaders,
+	}
+	for k, v := range c.Headers {
+		o.Headers.Set(k, v)
+	}
+	for _, line := range extraHeaders {
+		k, v, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		o.Headers.Set(k, v)
+	}
+	if c.Cookies != "" {
+		cookies, err := LoadNetscapeCookies(c.Cookies)
+		if err != nil {
+			return nil, fmt.Errorf("load cookies %s: %w", c.Cookies, err)
+		}
+		o.Cookies = cookies
+	}
+	return o, nil
+}
+
+// LimitOverrides 命令行指定的限速参数，零值表示未指定。重新加载配置文件后仍然优先
+type LimitOverrides struct {
+	LimitRate string
+	HostConns int
+	Workers   int
+}
+
+// Apply 用命令行参数覆盖配置文件中的值
+func (o LimitOverrides) Apply(c *Config) {
+	if o.LimitRate != "" {
+		c.LimitRate = o.LimitRate
+	}
+	if o.HostConns > 0 {
+		c.HostConns = o.HostConns
+	}
+	if o.Workers > 0 {
+		c.Workers = o.Workers
+	}
+}
+
+// WatchConfigReload 收到 SIGHUP 时重新读取配置文件并更新限速，用于工作时间临时限流。
+// overrides 为命令行参数，合并在配置文件之上
+func WatchConfigReload(path string, overrides LimitOverrides) {
+	if path == "" {
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				slog.Error("Failed to reload config", "path", path, "error", err)
+				continue
+			}
+			overrides.Apply(cfg)
+			if err := cfg.ApplyLimits(); err != nil {
+				slog.Error("Failed to apply limits", "error", err)
+			}
+		}
+	}()
+}
@@ -1,104 +1,534 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type fetchVideoMetaFunc func(videoURL string) *VideoMeta
+type fetchVideoMetaFunc func(bp *BrowserPool, videoURL string) (*VideoMeta, error)
 
 type VideoMeta struct {
 	URL     string
 	VideoID string
 	Title   string
 	M3u8URL string
+	Headers http.Header    // 下载 m3u8/key/ts 时需要的请求头，例如 Referer、Origin
+	Cookies []*http.Cookie // 下载时需要携带的 cookie，未指定 Domain 时作用于 m3u8 所在 host
+
+	Cover       string        // 封面图片地址
+	Description string        // 简介
+	Actors      []string      // 演员
+	Tags        []string      // 标签
+	Studio      string        // 片商
+	ReleaseDate string        // 发行日期，尽量为 2006-01-02 格式
+	Code        string        // 番号
+	SiteCode    bool          // Code 由站点的 DetailRule 提取，可以作为下载记录的唯一标识；通用规则从标题猜出的番号不可靠
+	Duration    time.Duration // 时长
+
+	Playlists []PlaylistCandidate // 浏览器抓取时出现过的所有 m3u8/mpd
+}
+
+// videoTask 已获取元数据、等待下载的视频，URL 为提交的地址（handler 可能改写了 Meta.URL）
+type videoTask struct {
+	URL  string
+	Meta *VideoMeta
 }
 
 type DLMaster struct {
 	videoURLs   []string
-	videoCh     chan VideoMeta
-	videoHandle map[string]fetchVideoMetaFunc
+	videoCh     chan videoTask
+	sites       *SiteRegistry
+	browserPool *BrowserPool
+	archive     *DownloadArchive // 为 nil 时不跳过已下载的视频
+	jobs        int              // 同时下载的视频数量
+	store       *JobStore        // 为 nil 时不持久化任务状态
+
+	mu       sync.Mutex
+	running  map[string]*M3u8Downloader // 正在下载的任务，key 为提交的地址
+	stopping bool                       // StopAll 之后开始的下载立即停止
+	queue    *urlQueue                  // serve 模式的待处理地址
+	events   *jobEvents                 // 推送给网页的任务更新
+	failed   atomic.Int64               // 本次运行失败的视频数量
 }
 
-func NewDLMaster() *DLMaster {
+// NewDLMaster browserCnt 为常驻的 Chrome 实例数量
+func NewDLMaster(browserCnt int) *DLMaster {
 	return &DLMaster{
-		videoCh:     make(chan VideoMeta, 3),
-		videoHandle: make(map[string]fetchVideoMetaFunc),
+		videoCh:     make(chan videoTask, 3),
+		sites:       NewSiteRegistry(),
+		browserPool: NewBrowserPool(browserCnt),
+		jobs:        1,
+		running:     make(map[string]*M3u8Downloader),
+		queue:       newURLQueue(),
+		events:      newJobEvents(),
+	}
+}
+
+// SetJobs 同时下载的视频数量，分片 worker 总数由 globalWorkerBudget 限制
+func (dm *DLMaster) SetJobs(jobs int) {
+	if jobs < 1 {
+		jobs = 1
 	}
+	dm.jobs = jobs
 }
 
-func (dm *DLMaster) RegisterVideoHandle(siteURL string, fn fetchVideoMetaFunc) {
+// Close 关闭浏览器池
+func (dm *DLMaster) Close() {
+	dm.browserPool.Close()
+}
+
+// RegisterVideoHandle 注册站点 host，同时匹配该 host 的所有子域名（www.、en. 等）
+func (dm *DLMaster) RegisterVideoHandle(siteURL string, fn fetchVideoMetaFunc) error {
 	u, err := url.Parse(siteURL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("%w: site %q", ErrInvalidURL, siteURL)
+	}
+	dm.sites.RegisterHost(funcName(fn), u.Hostname(), fn)
+	return nil
+}
+
+// RegisterVideoHandleRegex 使用完整 URL 正则注册站点
+func (dm *DLMaster) RegisterVideoHandleRegex(expr string, fn fetchVideoMetaFunc) error {
+	return dm.sites.RegisterRegex(funcName(fn), expr, fn)
+}
+
+// RegisterHostAlias 镜像域名使用 canonical 的 handler
+func (dm *DLMaster) RegisterHostAlias(alias, canonical string) {
+	dm.sites.RegisterAlias(alias, canonical)
+}
+
+// SetArchive 使用下载记录跳过以前下载过的视频，镜像域名按规范域名记录
+func (dm *DLMaster) SetArchive(da *DownloadArchive) {
+	da.SetSiteOf(dm.siteOf)
+	dm.archive = da
+}
+
+// SetJobStore 持久化每个地址的任务状态，Run 会继续之前未完成的任务
+func (dm *DLMaster) SetJobStore(js *JobStore) {
+	js.SetOnChange(dm.events.Publish)
+	dm.store = js
+}
+
+// updateJob 任务持久化失败只记录日志，不影响下载
+func (dm *DLMaster) updateJob(videoURL string, fn func(job *Job)) {
+	if dm.store == nil {
+		return
+	}
+	if err := dm.store.Update(videoURL, fn); err != nil {
+		slog.Error("Failed to update job", "url", videoURL, "error", err)
+	}
+}
+
+// failJob 记录失败原因，继续处理下一个地址
+func (dm *DLMaster) failJob(videoURL string, err error) {
+	dm.failed.Add(1)
+	// 处理期间被暂停或取消的任务保持原状态
+	dm.transitionJob(videoURL, JobFailed, func(job *Job) {
+		job.Errors = append(job.Errors, JobError{
+			Time:      time.Now(),
+			State:     job.State,
+			Message:   err.Error(),
+			StaleMeta: errors.Is(err, ErrPlaylistFetch) || errors.Is(err, ErrMetadataFetch),
+		})
+	}, activeJobStates...)
+}
+
+// activeJobStates 流水线正在处理的任务状态，暂停、取消、完成或失败后流水线不再修改任务
+var activeJobStates = []JobState{JobPending, JobFetchingMeta, JobDownloading, JobMerging}
+
+// transitionJob 任务当前状态在 from 中时先执行 fn（可以为 nil）再改为 to。返回 false 表示任务已被暂停、取消或删除，
+// 调用方应放弃处理；没有任务存储或持久化失败时返回 true，不影响下载
+func (dm *DLMaster) transitionJob(videoURL string, to JobState, fn func(job *Job), from ...JobState) bool {
+	if dm.store == nil {
+		return true
+	}
+	_, err := dm.store.UpdateIf(videoURL, func(job *Job) error {
+		if !slices.Contains(from, job.State) {
+			return fmt.Errorf("%w: %s job cannot become %s", ErrJobState, job.State, to)
+		}
+		if fn != nil {
+			fn(job)
+		}
+		job.State = to
+		return nil
+	})
+	if errors.Is(err, ErrJobState) || errors.Is(err, ErrJobNotFound) {
+		slog.Info("Job changed by another request, skipping", "url", videoURL, "error", err)
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to update job", "url", videoURL, "error", err)
+	}
+	return true
+}
+
+// siteOf 页面地址所属的站点：镜像域名替换为规范域名，去掉 www.
+func (dm *DLMaster) siteOf(rawURL string) string {
+	host, _ := dm.sites.resolveAlias(siteName(rawURL))
+	return host
+}
+
+// RegisterSiteDefinitions 注册配置文件中声明的站点，后注册的优先，因此可以覆盖内置 handler
+func (dm *DLMaster) RegisterSiteDefinitions(defs []SiteDefinition) error {
+	for i := range defs {
+		fn, err := defs[i].Handler()
+		if err != nil {
+			return err
+		}
+		for _, host := range defs[i].Hosts {
+			dm.sites.RegisterHost(defs[i].Name, host, fn)
+		}
+		for _, expr := range defs[i].URLRegex {
+			if err := dm.sites.RegisterRegex(defs[i].Name, expr, fn); err != nil {
+				return fmt.Errorf("site %q: %w", defs[i].Name, err)
+			}
+		}
+		slog.Debug("Registered site", "site", defs[i].Name, "hosts", defs[i].Hosts)
+	}
+	return nil
+}
+
+// ListSites 输出所有已注册的站点，并说明每个 URL 匹配到哪个 handler 以及原因
+func (dm *DLMaster) ListSites(w io.Writer, videoURLs []string) {
+	dm.sites.Print(w)
+	for _, vURL := range videoURLs {
+		if m, ok := dm.sites.Match(vURL); ok {
+			fmt.Fprintf(w, "%s\n  -> %s (%s %s): %s\n", vURL, m.Name, m.Kind, m.Pattern, m.Reason)
+		} else {
+			fmt.Fprintf(w, "%s\n  -> default handler: no site matched, expects m3u8_url;title\n", vURL)
+		}
+	}
+}
+
+// LoadSiteDefinitions 从文件加载并注册站点定义
+func (dm *DLMaster) LoadSiteDefinitions(path string) error {
+	defs, err := LoadSiteDefinitions(path)
 	if err != nil {
-		log.Fatalf("Invalid URL: %s", siteURL)
+		return err
 	}
-	dm.videoHandle[u.Host] = fn
+	return dm.RegisterSiteDefinitions(defs)
 }
 
+// Run 元数据抓取和下载流水线：抓取 worker 提前获取元数据放入 videoCh，最多 jobs 个视频同时下载，
+// 所有视频共享全局 worker 名额
 func (dm *DLMaster) Run() {
-	for i, vURL := range dm.videoURLs {
-		log.Printf("Processing %d/%d: %s\n", i+1, len(dm.videoURLs), vURL)
-		videoMeta := dm.FetchVideoMeta(vURL)
-		if videoMeta == nil || videoMeta.M3u8URL == "" {
-			log.Printf("Failed to fetch video metadata for URL: %s\n", vURL)
-			continue
-		}
-
-		bakM3u8URLCh := make(chan string, 1)
-		quitCh := make(chan bool, 1)
-		dl := NewM3u8Downloader(videoMeta, "", bakM3u8URLCh)
-
-		// todo: 是否有必要吗？
-		//go func(bakM3u8URLCh chan string, quitCh chan bool, meta *VideoMeta) {
-		//	for {
-		//		select {
-		//		case <-time.After(3 * time.Minute):
-		//			//todo: nil
-		//			videoMeta2 := dm.FetchVideoMeta(meta.URL)
-		//			if videoMeta2.M3u8URL != meta.M3u8URL {
-		//				bakM3u8URLCh <- videoMeta2.M3u8URL
-		//				return
-		//			}
-		//		case <-quitCh:
-		//			return
-		//		}
-		//	}
-		//}(bakM3u8URLCh, quitCh, videoMeta)
-
-		if err := dl.Download(); err != nil {
-			log.Printf("Failed to download url[%s]: %v\n", vURL, err)
-		}
-		quitCh <- true
-	}
-}
-
-func (dm *DLMaster) FetchVideoMeta(videoURL string) *VideoMeta {
-	u, err := url.Parse(videoURL)
+	if dm.store != nil {
+		// 新地址加入任务列表，之前未完成的任务一起继续
+		for _, vURL := range dm.videoURLs {
+			if _, err := dm.store.Enqueue(vURL, "", nil); err != nil {
+				slog.Error("Failed to enqueue job", "url", vURL, "error", err)
+			}
+		}
+		urls, err := dm.store.Unfinished()
+		if err != nil {
+			slog.Error("Failed to load jobs", "error", err)
+		} else {
+			dm.videoURLs = urls
+		}
+	}
+
+	urlCh := make(chan string)
+	go func() {
+		for i, vURL := range dm.videoURLs {
+			slog.Info("Processing", "index", i+1, "total", len(dm.videoURLs), "url", vURL)
+			urlCh <- vURL
+		}
+		close(urlCh)
+	}()
+	dm.pipeline(urlCh)
+	if n := dm.failed.Load(); n > 0 {
+		slog.Warn("Some videos failed", "failed", n, "total", len(dm.videoURLs))
+	}
+}
+
+// pipeline 处理 urlCh 中的地址直到 urlCh 关闭并且所有下载结束
+func (dm *DLMaster) pipeline(urlCh <-chan string) {
+	// 每个浏览器实例对应一个抓取 worker
+	fetchWg := &sync.WaitGroup{}
+	for w := 0; w < dm.browserPool.size; w++ {
+		fetchWg.Add(1)
+		go func() {
+			defer fetchWg.Done()
+			for vURL := range urlCh {
+				if videoMeta := dm.prepare(vURL); videoMeta != nil {
+					dm.videoCh <- videoTask{URL: vURL, Meta: videoMeta}
+				}
+			}
+		}()
+	}
+	go func() {
+		fetchWg.Wait()
+		close(dm.videoCh)
+	}()
+
+	downloadWg := &sync.WaitGroup{}
+	for w := 0; w < dm.jobs; w++ {
+		downloadWg.Add(1)
+		go func() {
+			defer downloadWg.Done()
+			for task := range dm.videoCh {
+				dm.download(task.URL, task.Meta)
+			}
+		}()
+	}
+	downloadWg.Wait()
+}
+
+// jobLog 输出带 url 属性的日志，同时记录到任务日志
+func (dm *DLMaster) jobLog(vURL string, level slog.Level, msg string, args ...any) {
+	slog.Log(context.Background(), level, msg, append([]any{"url", vURL}, args...)...)
+	dm.appendJobLog(vURL, formatLogLine(level, msg, args...))
+}
+
+func (dm *DLMaster) appendJobLog(vURL, line string) {
+	if dm.store == nil {
+		return
+	}
+	if err := dm.store.AppendLog(vURL, line); err != nil {
+		slog.Error("Failed to append job log", "url", vURL, "error", err)
+	}
+}
+
+// prepare 获取元数据，已下载、已暂停/取消或获取失败时返回 nil
+func (dm *DLMaster) prepare(vURL string) *VideoMeta {
+	if dm.archive != nil && dm.archive.HasURL(vURL) {
+		dm.jobLog(vURL, slog.LevelInfo, "Already in the download archive, skipping")
+		dm.transitionJob(vURL, JobDone, nil, activeJobStates...)
+		return nil
+	}
+	fetchURL := vURL
+	var job *Job
+	if dm.store != nil {
+		job, _ = dm.store.Get(vURL)
+	}
+	if job != nil {
+		if job.State == JobPaused || job.State == JobCanceled {
+			slog.Info("Skipping halted job", "url", vURL, "state", job.State)
+			return nil
+		}
+		// 上次已经获取过元数据并且未过期，不再打开浏览器
+		if job.MetaUsable() {
+			dm.jobLog(vURL, slog.LevelInfo, "Resuming job", "video_id", job.Meta.VideoID, "state", job.State, "completed", job.Completed, "segments", job.Segments)
+			return job.Meta
+		}
+		// 直接提交的 m3u8 地址，默认 handler 需要 m3u8_url;title 格式
+		if job.Title != "" && strings.Contains(vURL, ".m3u8") && !strings.Contains(vURL, ";") {
+			fetchURL = vURL + ";" + job.Title
+		}
+	}
+	// 出队后到这里之间可能已被暂停或取消
+	if !dm.transitionJob(vURL, JobFetchingMeta, nil, JobPending, JobFetchingMeta) {
+		return nil
+	}
+	videoMeta, err := dm.FetchVideoMeta(fetchURL)
 	if err != nil {
-		log.Printf("Invalid URL: %s", videoURL)
+		dm.jobLog(vURL, slog.LevelError, "Failed to fetch video metadata", "error", err)
+		dm.failJob(vURL, err)
 		return nil
 	}
-	fetchFunc, exists := dm.videoHandle[u.Host]
-	if !exists {
-		log.Printf("No handler registered for host: %s, use default handler", u.Host)
-		return dm.FetchDefaultVideoMeta(videoURL)
+	if job != nil {
+		if job.Title != "" {
+			videoMeta.Title = job.Title
+		}
+		if len(job.Headers) > 0 {
+			if videoMeta.Headers == nil {
+				videoMeta.Headers = http.Header{}
+			}
+			for k, vs := range job.Headers {
+				videoMeta.Headers[http.CanonicalHeaderKey(k)] = vs
+			}
+		}
+	}
+	if dm.archive != nil && dm.archive.Has(videoMeta) {
+		dm.jobLog(vURL, slog.LevelInfo, "Already in the download archive, skipping", "video_id", videoMeta.VideoID, "title", videoMeta.Title, "code", videoMeta.Code)
+		dm.transitionJob(vURL, JobDone, nil, JobFetchingMeta)
+		return nil
+	}
+	// 获取元数据期间被暂停或取消时元数据同样保存，继续时直接下载
+	dm.updateJob(vURL, func(job *Job) { job.Meta, job.MetaTime, job.VideoID = videoMeta, time.Now(), videoMeta.VideoID })
+	if !dm.transitionJob(vURL, JobPending, nil, JobFetchingMeta) {
+		return nil
 	}
-	return fetchFunc(videoURL)
+	return videoMeta
 }
 
-func (dm *DLMaster) FetchDefaultVideoMeta(m3u8URL string) *VideoMeta {
-	if strings.Contains(m3u8URL, ".m3u8") {
-		tmp := strings.SplitN(m3u8URL, ";", 2)
-		if len(tmp) != 2 {
-			log.Fatalf("Invalid m3u8 format, should be m3u8_url;title")
+func (dm *DLMaster) download(vURL string, videoMeta *VideoMeta) {
+	bakM3u8URLCh := make(chan string, 1)
+	quitCh := make(chan bool, 1)
+	dl, err := NewM3u8Downloader(videoMeta, "", bakM3u8URLCh)
+	if err != nil {
+		dm.jobLog(vURL, slog.LevelError, "Download failed", "video_id", videoMeta.VideoID, "error", err)
+		dm.failJob(vURL, err)
+		return
+	}
+	if dm.store != nil {
+		dl.OnProgress = func(completed, total int) {
+			dm.events.Progress(jobID(vURL), completed, total)
+			if err := dm.store.Progress(vURL, completed, total); err != nil {
+				slog.Error("Failed to update job", "url", vURL, "error", err)
+			}
 		}
-		return &VideoMeta{
-			URL:     tmp[0],
-			VideoID: hash(tmp[0]),
-			Title:   tmp[1],
-			M3u8URL: tmp[0],
+		dl.OnMerge = func() {
+			dm.updateJob(vURL, func(job *Job) {
+				if job.State == JobDownloading {
+					job.State = JobMerging
+				}
+			})
 		}
+		dl.OnLog = func(line string) { dm.appendJobLog(vURL, line) }
 	}
-	return nil
+	// 先登记再检查状态，之后的暂停/取消都能通过 Stop 停止下载
+	dm.track(vURL, dl)
+	defer dm.untrack(vURL)
+	// 等待下载期间可能已被暂停或取消；重启后继续的任务可能停在下载或合并状态
+	started := dm.transitionJob(vURL, JobDownloading, func(job *Job) {
+		job.Output = dl.basePath() + ".mp4"
+		if abs, err := filepath.Abs(job.Output); err == nil {
+			job.Output = abs
+		}
+	}, activeJobStates...)
+	if !started {
+		return
+	}
+
+	// todo: 是否有必要吗？
+	//go func(bakM3u8URLCh chan string, quitCh chan bool, meta *VideoMeta) {
+	//	for {
+	//		select {
+	//		case <-time.After(3 * time.Minute):
+	//			//todo: nil
+	//			videoMeta2 := dm.FetchVideoMeta(meta.URL)
+	//			if videoMeta2.M3u8URL != meta.M3u8URL {
+	//				bakM3u8URLCh <- videoMeta2.M3u8URL
+	//				return
+	//			}
+	//		case <-quitCh:
+	//			return
+	//		}
+	//	}
+	//}(bakM3u8URLCh, quitCh, videoMeta)
+
+	dm.jobLog(vURL, slog.LevelInfo, "Downloading", "video_id", videoMeta.VideoID, "title", videoMeta.Title)
+	if err := dl.Download(); errors.Is(err, errDownloadStopped) {
+		// 状态已由 Pause/Cancel 修改，退出时停止的任务保持原状态，重启后继续
+		dm.jobLog(vURL, slog.LevelInfo, "Download stopped", "video_id", videoMeta.VideoID)
+		if dm.jobCanceled(vURL) {
+			_ = os.RemoveAll(dl.tmpPath)
+		}
+	} else if err != nil {
+		dm.jobLog(vURL, slog.LevelError, "Download failed", "video_id", videoMeta.VideoID, "error", err)
+		dm.failJob(vURL, err)
+	} else {
+		dm.transitionJob(vURL, JobDone, nil, JobDownloading, JobMerging)
+		dm.jobLog(vURL, slog.LevelInfo, "Download finished", "video_id", videoMeta.VideoID)
+		if dm.archive != nil {
+			if err := dm.archive.Add(videoMeta, vURL, dl.basePath()+".mp4"); err != nil {
+				slog.Error("Failed to update download archive", "url", vURL, "error", err)
+			}
+		}
+	}
+	quitCh <- true
+}
+
+func (dm *DLMaster) jobCanceled(vURL string) bool {
+	if dm.store == nil {
+		return false
+	}
+	job, err := dm.store.Get(vURL)
+	return err == nil && job != nil && job.State == JobCanceled
+}
+
+// track 记录正在下载的任务，用于暂停、取消和退出时停止下载
+func (dm *DLMaster) track(vURL string, dl *M3u8Downloader) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.running[vURL] = dl
+	if dm.stopping {
+		dl.Stop()
+	}
+}
+
+func (dm *DLMaster) untrack(vURL string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	delete(dm.running, vURL)
+}
+
+// stopDownload 停止正在下载的任务，没有在下载时什么也不做
+func (dm *DLMaster) stopDownload(vURL string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dl, ok := dm.running[vURL]; ok {
+		dl.Stop()
+	}
+}
+
+// StopAll 停止所有正在下载的任务，任务状态不变，重启后继续
+func (dm *DLMaster) StopAll() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.stopping = true
+	for _, dl := range dm.running {
+		dl.Stop()
+	}
+}
+
+// FetchVideoMeta 使用匹配的站点 handler 获取元数据，没有找到 m3u8 时返回 ErrMetadataFetch
+func (dm *DLMaster) FetchVideoMeta(videoURL string) (*VideoMeta, error) {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	match, exists := dm.sites.Match(videoURL)
+	if !exists {
+		slog.Info("No handler registered, using default handler", "url", videoURL, "host", u.Host)
+		return observeMetaFetch("default", videoURL, func() (*VideoMeta, error) { return dm.FetchDefaultVideoMeta(videoURL) })
+	}
+	slog.Info("Using site handler", "url", videoURL, "site", match.Name, "reason", match.Reason)
+	return observeMetaFetch(match.Name, videoURL, func() (*VideoMeta, error) { return match.fn(dm.browserPool, videoURL) })
+}
+
+// observeMetaFetch 记录每个站点元数据抓取的耗时和结果，没有拿到 m3u8 地址算失败
+func observeMetaFetch(site, videoURL string, fetch func() (*VideoMeta, error)) (*VideoMeta, error) {
+	start := time.Now()
+	meta, err := fetch()
+	metricMetaFetch.ObserveSince(start, site)
+	if err == nil && (meta == nil || meta.M3u8URL == "") {
+		err = fmt.Errorf("%w: no playlist found on %s", ErrMetadataFetch, videoURL)
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metricMetaFetchResults.Inc(site, result)
+	return meta, err
+}
+
+// FetchDefaultVideoMeta 没有匹配站点时只支持 m3u8_url;title
+func (dm *DLMaster) FetchDefaultVideoMeta(m3u8URL string) (*VideoMeta, error) {
+	if !strings.Contains(m3u8URL, ".m3u8") {
+		return nil, fmt.Errorf("%w for %s", ErrNoHandler, m3u8URL)
+	}
+	tmp := strings.SplitN(m3u8URL, ";", 2)
+	if len(tmp) != 2 || tmp[1] == "" {
+		return nil, fmt.Errorf("%w: %s, m3u8 addresses should be m3u8_url;title", ErrInvalidURL, m3u8URL)
+	}
+	return &VideoMeta{
+		URL:     tmp[0],
+		VideoID: hash(tmp[0]),
+		Title:   tmp[1],
+		M3u8URL: tmp[0],
+	}, nil
 }
@@ -0,0 +1,24 @@
+package main
+
+import "errors"
+
+// 下载过程中的错误类型，用 errors.Is 判断，具体原因包装在错误信息中。
+// 单个视频出错只记录到任务中，不影响其他视频
+var (
+	// ErrInvalidURL 地址无法解析，或直接提交的 m3u8 地址不是 m3u8_url;title 格式
+	ErrInvalidURL = errors.New("invalid url")
+	// ErrNoHandler 没有匹配的站点，并且不是 m3u8 地址
+	ErrNoHandler = errors.New("no site handler")
+	// ErrUnsupportedSite 站点已注册但无法提取
+	ErrUnsupportedSite = errors.New("site not supported")
+	// ErrMetadataFetch 页面加载失败或没有找到 m3u8
+	ErrMetadataFetch = errors.New("failed to fetch video metadata")
+	// ErrPlaylistFetch 下载 m3u8 失败
+	ErrPlaylistFetch = errors.New("failed to fetch playlist")
+	// ErrSegmentFetch 分片在主备 m3u8 地址上都下载失败
+	ErrSegmentFetch = errors.New("failed to fetch segment")
+	// ErrKeyFetch 下载 EXT-X-KEY 失败
+	ErrKeyFetch = errors.New("failed to fetch key")
+	// ErrMerge 分片合并或 ffmpeg 转换失败
+	ErrMerge = errors.New("failed to merge segments")
+)
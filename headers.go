@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// globalRequestOverrides 配置文件/命令行指定的请求头和 cookie，优先级高于站点 handler 返回的值
+var globalRequestOverrides = &RequestOverrides{}
+
+// SiteHeaderRule 按站点 host 追加请求头
+type SiteHeaderRule struct {
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+type RequestOverrides struct {
+	Headers     http.Header
+	SiteHeaders []SiteHeaderRule
+	Cookies     []*http.Cookie
+}
+
+// newVideoHttpOptions 生成下载某个视频使用的请求选项，优先级：
+// 默认值 < VideoMeta（站点 handler）< 配置文件 site_headers < 全局 headers（配置文件/命令行）
+func newVideoHttpOptions(videoMeta *VideoMeta) *HttpOptions {
+	ro := NewHttpOptions(videoMeta.M3u8URL)
+	ro.Site = videoMeta.URL
+	for k, vs := range videoMeta.Headers {
+		ro.Headers[k] = append([]string(nil), vs...)
+	}
+	jar, _ := cookiejar.New(nil)
+	ro.Jar = jar
+	if u, err := url.Parse(videoMeta.M3u8URL); err == nil {
+		setJarCookies(jar, u, videoMeta.Cookies)
+	}
+	globalRequestOverrides.Apply(ro)
+	return ro
+}
+
+func (o *RequestOverrides) Apply(ro *HttpOptions) {
+	if site, err := url.Parse(ro.Site); err == nil {
+		for _, rule := range o.SiteHeaders {
+			if !matchHostPattern(rule.Host, site.Hostname()) {
+				continue
+			}
+			for k, v := range rule.Headers {
+				ro.Headers.Set(k, v)
+			}
+		}
+	}
+	for k, vs := range o.Headers {
+		ro.Headers[k] = append([]string(nil), vs...)
+	}
+	if ro.Jar != nil {
+		setJarCookies(ro.Jar, nil, o.Cookies)
+	}
+}
+
+// setJarCookies 带 Domain 的 cookie 按其 Domain 写入，否则写入 defaultURL
+func setJarCookies(jar http.CookieJar, defaultURL *url.URL, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		u := defaultURL
+		if domain := strings.TrimPrefix(c.Domain, "."); domain != "" {
+			scheme := "http"
+			if c.Secure {
+				scheme = "https"
+			}
+			u = &url.URL{Scheme: scheme, Host: domain, Path: "/"}
+		}
+		if u == nil {
+			continue
+		}
+		jar.SetCookies(u, []*http.Cookie{c})
+	}
+}
+
+// parseHeaderLine 解析 "Name: value" 格式的请求头
+func parseHeaderLine(line string) (string, string, error) {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid header %q, should be \"Name: value\"", line)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// LoadNetscapeCookies 读取 Netscape/Mozilla 格式的 cookies.txt（curl、yt-dlp、浏览器插件导出的格式）
+func LoadNetscapeCookies(path string) ([]*http.Cookie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// domain, include_subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("%s:%d: invalid cookie line", path, lineNo)
+		}
+		c := &http.Cookie{
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			HttpOnly: httpOnly,
+		}
+		if len(fields) > 6 {
+			c.Value = fields[6]
+		}
+		// include_subdomains 为 FALSE 的 cookie 也按域名 cookie 处理，会同时发送给子域名
+		c.Domain = fields[0]
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, scanner.Err()
+}
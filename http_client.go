@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sharedHttpClient 所有 m3u8、key、ts 请求共用的 http.Client，复用连接池
+var sharedHttpClient = NewHttpClient(DOWNLOAD_WORKERS)
+
+// HttpOptions 请求选项
+type HttpOptions struct {
+	Headers http.Header
+	Site    string         // 视频页面地址，用于按站点匹配代理规则
+	Jar     http.CookieJar // 站点 handler 和 cookies 文件提供的 cookie
+}
+
+// NewHttpClient 按 worker 数量调整连接池大小，不设置整体超时，
+// 慢速链路上大的 ts 文件只要持续有数据就不会被中断
+func NewHttpClient(workerCnt int) *http.Client {
+	transport := &http.Transport{
+		Proxy: proxyForRequest,
+		DialContext: (&net.Dialer{
+			Timeout:   CONNECT_TIMEOUT,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          workerCnt * 4,
+		MaxIdleConnsPerHost:   workerCnt,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   CONNECT_TIMEOUT,
+		ResponseHeaderTimeout: HEAD_TIMEOUT,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// httpGet 发起 GET 请求，返回的 Body 在 BODY_IDLE_TIMEOUT 内没有读到数据时会被中断。
+// 不设置 Accept-Encoding，由 Transport 自动协商 gzip 并透明解压
+func httpGet(rawURL string, ro *HttpOptions) (*http.Response, error) {
+	return httpGetContext(context.Background(), rawURL, ro)
+}
+
+// httpGetContext 与 httpGet 相同，ctx 可携带代理作用域等请求参数
+func httpGetContext(parent context.Context, rawURL string, ro *HttpOptions) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(parent)
+	if ro != nil {
+		ctx = withProxySite(ctx, ro.Site)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if ro != nil {
+		for k, vs := range ro.Headers {
+			req.Header[k] = append([]string(nil), vs...)
+		}
+		if ro.Jar != nil {
+			for _, c := range ro.Jar.Cookies(req.URL) {
+				req.AddCookie(c)
+			}
+		}
+	}
+	res, err := sharedHttpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = newIdleTimeoutBody(res.Body, BODY_IDLE_TIMEOUT, cancel)
+	return res, nil
+}
+
+// httpGetBytes 读取完整响应体，适用于 m3u8、key 等小文件
+func httpGetBytes(rawURL string, ro *HttpOptions) (int, []byte, error) {
+	res, err := httpGet(rawURL, ro)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	return res.StatusCode, data, err
+}
+
+// idleTimeoutBody 每次读到数据都会重置计时器，超时后取消请求
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	return &idleTimeoutBody{
+		body:    body,
+		timer:   time.AfterFunc(timeout, cancel),
+		timeout: timeout,
+		cancel:  cancel,
+	}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+// pauseIdle 限速器等待令牌期间停止计时
+func (b *idleTimeoutBody) pauseIdle() {
+	b.timer.Stop()
+}
+
+func (b *idleTimeoutBody) resumeIdle() {
+	b.timer.Reset(b.timeout)
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		b.timer.Stop()
+		b.cancel()
+	})
+	return err
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var errNoPlaylist = errors.New("no playlist found in page")
+
+// 默认匹配页面中第一个 m3u8 链接，兼容 JS 中转义过的 "\/"
+var defaultM3u8Regex = regexp.MustCompile(`(https?:(?:\\?/){2}[^\s'"<>]+?\.m3u8[^\s'"<>\\]*)`)
+
+// HTTPExtractRule 纯 HTTP 提取规则，所有字段可选，按 Follow -> JSObject -> Selector -> Regex 的顺序查找 m3u8
+type HTTPExtractRule struct {
+	TitleMeta     string `json:"title_meta"`     // 标题所在的 <meta name/property=...>
+	TitleSelector string `json:"title_selector"` // 标题所在元素的 CSS 选择器，取文本
+	M3u8Regex     string `json:"m3u8_regex"`     // 第一个分组为 m3u8 地址，为空时匹配页面中第一个 .m3u8 链接
+	M3u8Selector  string `json:"m3u8_selector"`  // m3u8 所在元素的 CSS 选择器，例如 "video source"
+	M3u8Attr      string `json:"m3u8_attr"`      // 配合 M3u8Selector 使用的属性，默认 src
+	JSObject      string `json:"js_object"`      // 页面内嵌的 JS 对象变量名，例如 "player"
+	JSPath        string `json:"js_path"`        // JS 对象中 m3u8 的路径，例如 "hls.url"、"sources.0.file"
+	FollowRegex   string `json:"follow_regex"`   // 第一个分组为接口地址（例如 get_video_info.php），m3u8 从接口响应中提取
+
+	Details *DetailRule `json:"details,omitempty"` // 封面、演员、标签等信息
+}
+
+// FetchHTTPVideoMeta 不启动浏览器，直接请求页面提取标题和 m3u8 地址
+func FetchHTTPVideoMeta(videoURL string, rule *HTTPExtractRule) (*VideoMeta, error) {
+	if rule == nil {
+		rule = &HTTPExtractRule{}
+	}
+	page, err := FetchPage(videoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	m3u8URL, err := rule.findM3u8(page)
+	if err != nil {
+		return nil, err
+	}
+	title := ""
+	if rule.TitleMeta != "" {
+		title = page.Meta(rule.TitleMeta)
+	}
+	if title == "" && rule.TitleSelector != "" {
+		title, _ = page.SelectAttr(rule.TitleSelector, "")
+	}
+	if title == "" {
+		title = page.Title()
+	}
+	slog.Info("Fetched metadata via HTTP", "url", videoURL, "title", title, "m3u8", m3u8URL)
+	// 页面已经请求成功，地址一定合法
+	origin, _ := getHost(videoURL, "v2")
+	videoMeta := &VideoMeta{
+		URL:     videoURL,
+		VideoID: hash(videoURL),
+		Title:   title,
+		M3u8URL: m3u8URL,
+		Headers: http.Header{
+			"Referer": {videoURL},
+			"Origin":  {origin},
+		},
+		Cookies: page.Cookies(m3u8URL),
+	}
+	page.ExtractDetails(videoMeta, rule.Details)
+	return videoMeta, nil
+}
+
+func (r *HTTPExtractRule) findM3u8(page *Page) (string, error) {
+	if r.FollowRegex != "" {
+		infoURL, err := page.Regex(r.FollowRegex)
+		if err != nil {
+			return "", err
+		}
+		if infoURL == "" {
+			return "", fmt.Errorf("follow url not found: %s", r.FollowRegex)
+		}
+		info, err := page.Follow(infoURL)
+		if err != nil {
+			return "", err
+		}
+		if v, err := info.JSON(); err == nil {
+			if s := findStringContaining(v, ".m3u8"); s != "" {
+				return info.ResolveURL(s), nil
+			}
+		}
+		page = info
+	}
+	if r.JSObject != "" {
+		obj, err := page.JSObject(r.JSObject)
+		if err != nil {
+			return "", err
+		}
+		if s, ok := lookupPath(obj, r.JSPath).(string); ok && s != "" {
+			return page.ResolveURL(s), nil
+		}
+	}
+	if r.M3u8Selector != "" {
+		attr := r.M3u8Attr
+		if attr == "" {
+			attr = "src"
+		}
+		if s, err := page.SelectAttr(r.M3u8Selector, attr); err != nil {
+			return "", err
+		} else if s != "" {
+			return page.ResolveURL(s), nil
+		}
+	}
+	expr := r.M3u8Regex
+	if expr == "" {
+		expr = defaultM3u8Regex.String()
+	}
+	s, err := page.Regex(expr)
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", errNoPlaylist
+	}
+	return page.ResolveURL(s), nil
+}
+
+// Page 通过 HTTP 获取的页面
+type Page struct {
+	URL  string
+	Body string
+	ro   *HttpOptions
+	doc  *html.Node
+}
+
+// FetchPage 使用共享的 http.Client 获取页面，cookie 会在后续 Follow 请求中保留
+func FetchPage(pageURL string, headers http.Header) (*Page, error) {
+	jar, _ := cookiejar.New(nil)
+	ro := &HttpOptions{
+		Headers: http.Header{
+			"User-Agent":      {BROWSER_USER_AGENT},
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language": {"zh-CN,zh;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"},
+		},
+		Site: pageURL,
+		Jar:  jar,
+	}
+	for k, vs := range headers {
+		ro.Headers[k] = vs
+	}
+	return fetchPage(pageURL, ro)
+}
+
+// fetchPage 页面和接口请求使用 meta 代理规则
+func fetchPage(pageURL string, ro *HttpOptions) (*Page, error) {
+	res, err := httpGetContext(withProxyScope(context.Background(), proxyScopeMeta), pageURL, ro)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if u, err := url.Parse(pageURL); err == nil {
+		ro.Jar.SetCookies(u, res.Cookies())
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: status code %d", pageURL, res.StatusCode)
+	}
+	return &Page{URL: pageURL, Body: string(body), ro: ro}, nil
+}
+
+// Follow 以当前页面为 Referer 请求另一个地址，共享 cookie
+func (p *Page) Follow(ref string) (*Page, error) {
+	ro := &HttpOptions{Headers: p.ro.Headers.Clone(), Site: p.ro.Site, Jar: p.ro.Jar}
+	ro.Headers.Set("Referer", p.URL)
+	ro.Headers.Set("Accept", "*/*")
+	return fetchPage(p.ResolveURL(ref), ro)
+}
+
+// Cookies 返回作用于 targetURL 的 cookie
+func (p *Page) Cookies(targetURL string) []*http.Cookie {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil
+	}
+	return p.ro.Jar.Cookies(u)
+}
+
+// ResolveURL 将相对地址转换为绝对地址，并还原 JS/HTML 中的转义
+func (p *Page) ResolveURL(ref string) string {
+	ref = html.UnescapeString(strings.ReplaceAll(ref, `\/`, "/"))
+	base, err := url.Parse(p.URL)
+	if err != nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func (p *Page) Doc() *html.Node {
+	if p.doc == nil {
+		doc, err := html.Parse(strings.NewReader(p.Body))
+		if err != nil {
+			doc = &html.Node{Type: html.DocumentNode}
+		}
+		p.doc = doc
+	}
+	return p.doc
+}
+
+// Meta 返回 <meta name=... content=...> 或 <meta property=... content=...> 的 content
+func (p *Page) Meta(name string) string {
+	for _, n := range mustSelector("meta").MatchAll(p.Doc()) {
+		if nodeAttr(n, "name") == name || nodeAttr(n, "property") == name || nodeAttr(n, "itemprop") == name {
+			return strings.TrimSpace(nodeAttr(n, "content"))
+		}
+	}
+	return ""
+}
+
+func (p *Page) Title() string {
+	nodes := mustSelector("title").MatchAll(p.Doc())
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodeText(nodes[0])
+}
+
+// Regex 返回第一个分组（没有分组时返回整个匹配）
+func (p *Page) Regex(expr string) (string, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	m := reg.FindStringSubmatch(p.Body)
+	switch {
+	case len(m) > 1:
+		return m[1], nil
+	case len(m) == 1:
+		return m[0], nil
+	}
+	return "", nil
+}
+
+// SelectAttr 返回第一个匹配元素的属性，attr 为空时返回元素文本
+func (p *Page) SelectAttr(selector, attr string) (string, error) {
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range sel.MatchAll(p.Doc()) {
+		if attr == "" {
+			return nodeText(n), nil
+		}
+		if v, ok := lookupAttr(n, attr); ok {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// SelectAll 返回所有匹配元素的文本
+func (p *Page) SelectAll(selector string) ([]string, error) {
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, n := range sel.MatchAll(p.Doc()) {
+		if text := nodeText(n); text != "" {
+			result = append(result, text)
+		}
+	}
+	return result, nil
+}
+
+// JSON 将整个响应解析为 JSON
+func (p *Page) JSON() (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(p.Body), &v)
+	return v, err
+}
+
+// JSObject 解析页面脚本中 `name = {...}`、`name: {...}` 形式的对象字面量
+func (p *Page) JSObject(name string) (interface{}, error) {
+	src, ok := extractJSObject(p.Body, name)
+	if !ok {
+		return nil, fmt.Errorf("js object %q not found", name)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(jsObjectToJSON(src)), &v); err != nil {
+		return nil, fmt.Errorf("parse js object %q: %w", name, err)
+	}
+	return v, nil
+}
+
+func mustSelector(s string) Selector {
+	sel, err := CompileSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// extractJSObject 找到变量赋值后的 {...} 或 [...]，返回括号匹配的完整字面量
+func extractJSObject(src, name string) (string, bool) {
+	reg := regexp.MustCompile(`(?:^|[^\w$.])["']?` + regexp.QuoteMeta(name) + `["']?\s*[:=]\s*([{\[])`)
+	loc := reg.FindStringSubmatchIndex(src)
+	if loc == nil {
+		return "", false
+	}
+	start := loc[2]
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '"', '\'', '`':
+			i = jsStringEnd(src, i)
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return src[start : i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// jsStringEnd 返回以 src[i] 为引号的字符串字面量的结束引号位置，
+// 模板字符串的 ${...} 中可以嵌套字符串和模板，未闭合时返回 len(src)
+func jsStringEnd(src string, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch {
+		case src[j] == '\\':
+			j++
+		case src[j] == quote:
+			return j
+		case quote == '`' && strings.HasPrefix(src[j:], "${"):
+			j = jsTemplateExprEnd(src, j+2)
+		}
+	}
+	return len(src)
+}
+
+// jsTemplateExprEnd 返回模板表达式（从 ${ 之后开始）对应 } 的位置
+func jsTemplateExprEnd(src string, i int) int {
+	depth := 0
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '"', '\'', '`':
+			i = jsStringEnd(src, i)
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(src)
+}
+
+// jsObjectToJSON 将 JS 对象字面量转换为 JSON：支持未加引号的 key、单引号/模板字符串、
+// 尾逗号、注释，undefined 和变量引用转换为 null
+func jsObjectToJSON(src string) string {
+	var sb strings.Builder
+	// nextSignificant 跳过空白和注释，返回下一个有效字符的位置
+	nextSignificant := func(i int) int {
+		for i < len(src) {
+			switch {
+			case src[i] == ' ' || src[i] == '\t' || src[i] == '\n' || src[i] == '\r':
+				i++
+			case strings.HasPrefix(src[i:], "//"):
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			case strings.HasPrefix(src[i:], "/*"):
+				end := strings.Index(src[i+2:], "*/")
+				if end < 0 {
+					return len(src)
+				}
+				i += end + 4
+			default:
+				return i
+			}
+		}
+		return i
+	}
+	for i := 0; i < len(src); {
+		j := nextSignificant(i)
+		if j != i {
+			sb.WriteByte(' ')
+			i = j
+			continue
+		}
+		ch := src[i]
+		switch {
+		case ch == '"' || ch == '\'' || ch == '`':
+			end := i + 1
+			var content strings.Builder
+			for end < len(src) && src[end] != ch {
+				if ch == '`' && strings.HasPrefix(src[end:], "${") {
+					// 模板中的表达式原样保留，其中可以嵌套字符串和模板
+					close := min(jsTemplateExprEnd(src, end+2)+1, len(src))
+					raw, _ := json.Marshal(src[end:close])
+					content.Write(raw[1 : len(raw)-1])
+					end = close
+					continue
+				}
+				if src[end] == '\\' && end+1 < len(src) {
+					next := src[end+1]
+					switch next {
+					case '\'', '`':
+						content.WriteByte(next)
+					case 'x':
+						content.WriteString(`\u00`)
+					default:
+						content.WriteByte('\\')
+						content.WriteByte(next)
+					}
+					end += 2
+					continue
+				}
+				if src[end] == '"' {
+					content.WriteString(`\"`)
+				} else if src[end] == '\n' {
+					content.WriteString(`\n`)
+				} else if src[end] == '\r' {
+					content.WriteString(`\r`)
+				} else if src[end] == '\t' {
+					content.WriteString(`\t`)
+				} else {
+					content.WriteByte(src[end])
+				}
+				end++
+			}
+			sb.WriteByte('"')
+			sb.WriteString(content.String())
+			sb.WriteByte('"')
+			i = end + 1
+		case ch == ',':
+			if k := nextSignificant(i + 1); k < len(src) && (src[k] == '}' || src[k] == ']') {
+				i++
+				continue
+			}
+			sb.WriteByte(ch)
+			i++
+		case ch == '_' || ch == '$' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			end := i
+			for end < len(src) && (isSelectorIdentChar(src[end]) || src[end] == '$') {
+				end++
+			}
+			word := src[i:end]
+			k := nextSignificant(end)
+			switch {
+			case k < len(src) && src[k] == ':':
+				sb.WriteString(strconv.Quote(word))
+			case word == "true" || word == "false" || word == "null":
+				sb.WriteString(word)
+			default:
+				sb.WriteString("null")
+				// 跳过 a.b.c、fn(...) 这类表达式
+				for end < len(src) && (src[end] == '.' || isSelectorIdentChar(src[end])) {
+					end++
+				}
+				if end < len(src) && src[end] == '(' {
+					if close := strings.IndexByte(src[end:], ')'); close >= 0 {
+						end += close + 1
+					}
+				}
+			}
+			i = end
+		default:
+			sb.WriteByte(ch)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// lookupPath 按 "a.b.0.c" 路径取值，path 为空时返回 v 本身
+func lookupPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil
+			}
+			v = t[idx]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// findStringContaining 深度优先查找第一个包含 substr 的字符串值
+func findStringContaining(v interface{}, substr string) string {
+	switch t := v.(type) {
+	case string:
+		if strings.Contains(t, substr) {
+			return t
+		}
+	case map[string]interface{}:
+		for _, child := range t {
+			if s := findStringContaining(child, substr); s != "" {
+				return s
+			}
+		}
+	case []interface{}:
+		for _, child := range t {
+			if s := findStringContaining(child, substr); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
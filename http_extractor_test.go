@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSObjectToJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want interface{}
+	}{
+		{"json", `{"a": 1, "b": [true, null]}`, map[string]interface{}{"a": 1.0, "b": []interface{}{true, nil}}},
+		{"unquoted keys", `{a: 1, $b: "x", _c: 2}`, map[string]interface{}{"a": 1.0, "$b": "x", "_c": 2.0}},
+		{"single quotes", `{'a': 'it\'s "quoted"'}`, map[string]interface{}{"a": `it's "quoted"`}},
+		{"trailing commas", `{a: [1, 2, ], b: {c: 3, }, }`, map[string]interface{}{"a": []interface{}{1.0, 2.0}, "b": map[string]interface{}{"c": 3.0}}},
+		{"comments", "{a: 1, // line\n /* block, */ b: 2}", map[string]interface{}{"a": 1.0, "b": 2.0}},
+		{"references", `{a: undefined, b: window.x.y, c: fn(1), d: false}`, map[string]interface{}{"a": nil, "b": nil, "c": nil, "d": false}},
+		{"template", "{src: `https://cdn.test/v.m3u8`}", map[string]interface{}{"src": "https://cdn.test/v.m3u8"}},
+		{"multiline template", "{a: `x\n\ty`}", map[string]interface{}{"a": "x\n\ty"}},
+		{"template expression", "{src: `${host}/v.m3u8`}", map[string]interface{}{"src": "${host}/v.m3u8"}},
+		{"nested template", "{src: `${base ? `${base}/hls` : \"/}\"}/v.m3u8`, b: 1}", map[string]interface{}{"src": "${base ? `${base}/hls` : \"/}\"}/v.m3u8", "b": 1.0}},
+		{"hex escape", `{a: '\x41'}`, map[string]interface{}{"a": "A"}},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		out := jsObjectToJSON(tt.src)
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Errorf("%s: %q -> invalid JSON %q: %v", tt.name, tt.src, out, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q -> %#v, want %#v", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExtractJSObject(t *testing.T) {
+	tests := []struct {
+		src, name, want string
+		ok              bool
+	}{
+		{`var player = {a: 1}; var x = 2;`, "player", `{a: 1}`, true},
+		{`cfg={a:{b:[1,2]}};`, "cfg", `{a:{b:[1,2]}}`, true},
+		{`init({"sources": [{src: "a}"}]})`, "sources", `[{src: "a}"}]`, true},
+		{"const p = {a: `}${`{`}`, b: '}'}; }", "p", "{a: `}${`{`}`, b: '}'}", true},
+		{`var xplayer = {a: 1}; var player = {b: 2};`, "player", `{b: 2}`, true},
+		{`var player = {a: 1`, "player", "", false},
+		{`var other = {a: 1}`, "player", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := extractJSObject(tt.src, tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("extractJSObject(%q, %q) = %q, %v, want %q, %v", tt.src, tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// JobState 视频任务状态
+type JobState string
+
+const (
+	JobPending      JobState = "pending"
+	JobFetchingMeta JobState = "fetching-meta"
+	JobDownloading  JobState = "downloading"
+	JobMerging      JobState = "merging"
+	JobDone         JobState = "done"
+	JobFailed       JobState = "failed"
+	JobPaused       JobState = "paused"   // 手动暂停，重启后不会自动继续
+	JobCanceled     JobState = "canceled" // 手动取消，已下载的分片会被删除
+)
+
+const (
+	// JOB_PROGRESS_INTERVAL 分片进度写入数据库的最小间隔
+	JOB_PROGRESS_INTERVAL = 2 * time.Second
+	// JOB_LOG_LINES 每个任务保留的日志行数
+	JOB_LOG_LINES = 1000
+	// JOB_META_TTL 已保存元数据的有效期，m3u8 的签名参数和 cookie 一般很快过期，超过后继续任务时重新获取
+	JOB_META_TTL = 15 * time.Minute
+)
+
+var (
+	jobsBucket = []byte("jobs")
+	logsBucket = []byte("logs") // 每个任务一个子 bucket，key 为递增序号
+)
+
+// JobError 任务失败记录
+type JobError struct {
+	Time    time.Time `json:"time"`
+	State   JobState  `json:"state"` // 出错时所处的阶段
+	Message string    `json:"message"`
+	// StaleMeta 错误可能由过期的页面元数据（m3u8 签名、cookie）导致，继续时需要重新获取
+	StaleMeta bool `json:"stale_meta,omitempty"`
+}
+
+// Job 一个视频地址的下载任务，重启后根据 State 和 Meta 继续
+type Job struct {
+	ID        string      `json:"id"`
+	URL       string      `json:"url"`
+	State     JobState    `json:"state"`
+	Title     string      `json:"title,omitempty"`   // 提交时指定的标题，覆盖站点提取的标题
+	Headers   http.Header `json:"headers,omitempty"` // 提交时指定的请求头，附加到 m3u8/key/ts 请求
+	Meta      *VideoMeta  `json:"meta,omitempty"`    // 已获取的元数据，有效期内重启后不再打开浏览器
+	MetaTime  time.Time   `json:"meta_time,omitempty"`
+	VideoID   string      `json:"video_id,omitempty"` // 分片目录，重新获取元数据前后不变，元数据清除后用于删除分片
+	Output    string      `json:"output,omitempty"`
+	Segments  int         `json:"segments"`  // 分片总数
+	Completed int         `json:"completed"` // 已下载的分片数
+	Errors    []JobError  `json:"errors,omitempty"`
+	Created   time.Time   `json:"created"`
+	Updated   time.Time   `json:"updated"`
+}
+
+// Finished 已完成、已失败或已取消，重启时不会自动继续
+func (j *Job) Finished() bool {
+	return j.State == JobDone || j.State == JobFailed || j.State == JobCanceled
+}
+
+// MetaUsable 已保存的元数据未过期，并且最近一次失败不是由过期的元数据导致
+func (j *Job) MetaUsable() bool {
+	if j.Meta == nil || j.MetaTime.IsZero() || time.Since(j.MetaTime) > JOB_META_TTL {
+		return false
+	}
+	if n := len(j.Errors); n > 0 && j.Errors[n-1].StaleMeta && j.Errors[n-1].Time.After(j.MetaTime) {
+		return false
+	}
+	return true
+}
+
+// segmentVideoID 分片目录对应的 video id，兼容没有 VideoID 字段的旧任务
+func (j *Job) segmentVideoID() string {
+	if j.VideoID == "" && j.Meta != nil {
+		return j.Meta.VideoID
+	}
+	return j.VideoID
+}
+
+// ClearMeta 丢弃已保存的元数据，下次处理时重新获取，已下载的分片保留
+func (j *Job) ClearMeta() {
+	j.Meta, j.MetaTime = nil, time.Time{}
+}
+
+// JobStore 基于 bbolt 的任务持久化，进程退出后重启可以从中断的位置继续
+type JobStore struct {
+	db *bolt.DB
+
+	mu           sync.Mutex
+	lastProgress map[string]time.Time // 任务 ID -> 上次写入进度的时间，任务结束或删除时移除
+
+	onChange func(job *Job) // 每次写入后调用，用于推送任务更新
+}
+
+// SetOnChange 任务新增或修改后调用 fn，fn 不能再写入数据库
+func (js *JobStore) SetOnChange(fn func(job *Job)) {
+	js.onChange = fn
+}
+
+func (js *JobStore) changed(job *Job) {
+	if js.onChange != nil && job != nil {
+		js.onChange(job)
+	}
+}
+
+func OpenJobStore(path string) (*JobStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open job store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(logsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &JobStore{db: db, lastProgress: make(map[string]time.Time)}, nil
+}
+
+func (js *JobStore) Close() error {
+	return js.db.Close()
+}
+
+func jobID(videoURL string) string {
+	return hash(videoURL)
+}
+
+// Enqueue 添加任务，已存在的任务保持原状态，失败或取消的任务重新排队。title、headers 可以为空
+func (js *JobStore) Enqueue(videoURL, title string, headers http.Header) (*Job, error) {
+	var job *Job
+	err := js.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		id := jobID(videoURL)
+		now := time.Now()
+		if data := b.Get([]byte(id)); data != nil {
+			job = &Job{}
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			if job.State != JobFailed && job.State != JobCanceled {
+				return nil
+			}
+			job.State = JobPending
+			job.ClearMeta()
+		} else {
+			job = &Job{ID: id, URL: videoURL, State: JobPending, Created: now}
+		}
+		if title != "" {
+			job.Title = title
+		}
+		if len(headers) > 0 {
+			job.Headers = headers
+		}
+		job.Updated = now
+		return putJob(b, job)
+	})
+	if err == nil {
+		js.changed(job)
+	}
+	return job, err
+}
+
+func putJob(b *bolt.Bucket, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(job.ID), data)
+}
+
+// Get 任务不存在时返回 nil
+func (js *JobStore) Get(videoURL string) (*Job, error) {
+	var job *Job
+	err := js.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(jobID(videoURL)))
+		if data == nil {
+			return nil
+		}
+		job = &Job{}
+		return json.Unmarshal(data, job)
+	})
+	return job, err
+}
+
+// GetByID 任务不存在时返回 nil
+func (js *JobStore) GetByID(id string) (*Job, error) {
+	var job *Job
+	err := js.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		job = &Job{}
+		return json.Unmarshal(data, job)
+	})
+	return job, err
+}
+
+// Update 在事务中修改任务
+func (js *JobStore) Update(videoURL string, fn func(job *Job)) error {
+	_, err := js.UpdateIf(videoURL, func(job *Job) error {
+		fn(job)
+		return nil
+	})
+	return err
+}
+
+// UpdateIf 在事务中修改任务，fn 返回错误时不修改，返回修改后的任务
+func (js *JobStore) UpdateIf(videoURL string, fn func(job *Job) error) (*Job, error) {
+	job := &Job{}
+	err := js.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		data := b.Get([]byte(jobID(videoURL)))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrJobNotFound, videoURL)
+		}
+		if err := json.Unmarshal(data, job); err != nil {
+			return err
+		}
+		if err := fn(job); err != nil {
+			return err
+		}
+		job.Updated = time.Now()
+		return putJob(b, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if job.Finished() {
+		js.forgetProgress(job.ID)
+	}
+	js.changed(job)
+	return job, nil
+}
+
+// Progress 记录分片进度，按 JOB_PROGRESS_INTERVAL 节流，下载完成时总是写入
+func (js *JobStore) Progress(videoURL string, completed, total int) error {
+	id := jobID(videoURL)
+	js.mu.Lock()
+	last := js.lastProgress[id]
+	if completed < total && time.Since(last) < JOB_PROGRESS_INTERVAL {
+		js.mu.Unlock()
+		return nil
+	}
+	js.lastProgress[id] = time.Now()
+	js.mu.Unlock()
+	return js.Update(videoURL, func(job *Job) {
+		job.Completed, job.Segments = completed, total
+	})
+}
+
+func (js *JobStore) forgetProgress(id string) {
+	js.mu.Lock()
+	delete(js.lastProgress, id)
+	js.mu.Unlock()
+}
+
+// Delete 删除任务及其日志
+func (js *JobStore) Delete(id string) error {
+	js.forgetProgress(id)
+	return js.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(jobsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if tx.Bucket(logsBucket).Bucket([]byte(id)) == nil {
+			return nil
+		}
+		return tx.Bucket(logsBucket).DeleteBucket([]byte(id))
+	})
+}
+
+// List 按创建时间排序
+func (js *JobStore) List() ([]*Job, error) {
+	var jobs []*Job
+	err := js.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs, err
+}
+
+// Unfinished 重启时需要继续的任务地址，不包含暂停的任务
+func (js *JobStore) Unfinished() ([]string, error) {
+	jobs, err := js.List()
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, job := range jobs {
+		if !job.Finished() && job.State != JobPaused {
+			urls = append(urls, job.URL)
+		}
+	}
+	return urls, nil
+}
+
+// Print 输出所有任务及最近一次错误
+func (js *JobStore) Print(w io.Writer) error {
+	jobs, err := js.List()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		title := ""
+		if job.Meta != nil {
+			title = job.Meta.Title
+		}
+		fmt.Fprintf(w, "%-13s %5d/%-5d %s  %s\n", job.State, job.Completed, job.Segments, job.URL, title)
+		if n := len(job.Errors); n > 0 {
+			last := job.Errors[n-1]
+			fmt.Fprintf(w, "    %s [%s] %s\n", last.Time.Format("2006-01-02 15:04:05"), last.State, last.Message)
+		}
+	}
+	return nil
+}
+
+// AppendLog 追加一行任务日志，超过 JOB_LOG_LINES 时删除最早的一行
+func (js *JobStore) AppendLog(videoURL, line string) error {
+	return js.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(logsBucket).CreateBucketIfNotExists([]byte(jobID(videoURL)))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry := time.Now().Format("2006-01-02 15:04:05") + " " + line
+		if err := b.Put(seqKey(seq), []byte(entry)); err != nil {
+			return err
+		}
+		if seq > JOB_LOG_LINES {
+			return b.Delete(seqKey(seq - JOB_LOG_LINES))
+		}
+		return nil
+	})
+}
+
+// Logs 按时间顺序返回任务日志
+func (js *JobStore) Logs(id string) ([]string, error) {
+	var lines []string
+	err := js.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logsBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			lines = append(lines, string(v))
+			return nil
+		})
+	})
+	return lines, err
+}
+
+// seqKey 大端序保证 ForEach 按写入顺序遍历
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
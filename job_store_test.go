@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobMetaUsable(t *testing.T) {
+	now := time.Now()
+	meta := &VideoMeta{VideoID: "v"}
+	tests := []struct {
+		name string
+		job  Job
+		want bool
+	}{
+		{"fresh", Job{Meta: meta, MetaTime: now}, true},
+		{"no meta", Job{MetaTime: now}, false},
+		{"no fetch time", Job{Meta: meta}, false},
+		{"expired", Job{Meta: meta, MetaTime: now.Add(-JOB_META_TTL - time.Minute)}, false},
+		{"playlist error after fetch", Job{Meta: meta, MetaTime: now.Add(-time.Minute), Errors: []JobError{{Time: now, StaleMeta: true}}}, false},
+		{"other error after fetch", Job{Meta: meta, MetaTime: now.Add(-time.Minute), Errors: []JobError{{Time: now}}}, true},
+		{"playlist error before refetch", Job{Meta: meta, MetaTime: now, Errors: []JobError{{Time: now.Add(-time.Minute), StaleMeta: true}}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.job.MetaUsable(); got != tt.want {
+			t.Errorf("%s: MetaUsable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJobStoreForgetsProgressOfFinishedJobs(t *testing.T) {
+	js, err := OpenJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	for _, u := range []string{"https://stub.test/v/1", "https://stub.test/v/2"} {
+		if _, err := js.Enqueue(u, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := js.Progress(u, 1, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(js.lastProgress) != 2 {
+		t.Fatalf("lastProgress has %d entries, want 2", len(js.lastProgress))
+	}
+	if err := js.Update("https://stub.test/v/1", func(job *Job) { job.State = JobDone }); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.Delete(jobID("https://stub.test/v/2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.lastProgress) != 0 {
+		t.Errorf("lastProgress = %v, want empty", js.lastProgress)
+	}
+}
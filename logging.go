@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LOG_FILE_MAX_SIZE 日志文件超过该大小后轮转
+	LOG_FILE_MAX_SIZE = 100 * 1024 * 1024
+	// LOG_FILE_BACKUPS 默认保留的旧日志文件数量，<file>.1 最新
+	LOG_FILE_BACKUPS = 5
+)
+
+// LogConfig 日志配置，日志同时输出到终端和 File
+type LogConfig struct {
+	Level      string `json:"level"`       // debug、info（默认）、warn、error
+	Format     string `json:"format"`      // text（默认）或 json
+	File       string `json:"file"`        // 日志文件，按大小轮转，级别与 Level 相同
+	MaxSize    string `json:"max_size"`    // 单个日志文件的大小，例如 "50M"，默认 LOG_FILE_MAX_SIZE
+	MaxBackups int    `json:"max_backups"` // 保留的旧日志文件数量，默认 LOG_FILE_BACKUPS
+	Quiet      bool   `json:"quiet"`       // 终端只输出错误，不影响日志文件
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// SetupLogging 设置默认的 slog.Logger，console 为终端输出（tty 进度模式下是 TTYReporter）。
+// 标准库 log 的输出也会转到这里。返回的 io.Closer 用于关闭日志文件
+func SetupLogging(cfg LogConfig, console io.Writer) (io.Closer, error) {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	consoleLevel := level
+	if cfg.Quiet {
+		consoleLevel = max(level, slog.LevelError)
+	}
+	consoleHandler, err := newLogHandler(console, cfg.Format, consoleLevel)
+	if err != nil {
+		return nil, err
+	}
+	handlers := multiHandler{consoleHandler}
+	var closer io.Closer = io.NopCloser(nil)
+	if cfg.File != "" {
+		maxSize := int64(LOG_FILE_MAX_SIZE)
+		if cfg.MaxSize != "" {
+			if maxSize, err = parseByteRate(cfg.MaxSize); err != nil || maxSize <= 0 {
+				return nil, fmt.Errorf("invalid log max_size %q", cfg.MaxSize)
+			}
+		}
+		backups := cfg.MaxBackups
+		if backups <= 0 {
+			backups = LOG_FILE_BACKUPS
+		}
+		f, err := openRotatingFile(cfg.File, maxSize, backups)
+		if err != nil {
+			return nil, err
+		}
+		// 文件中的日志总是带时间，json 便于采集，text 便于查看，与终端一致
+		fileHandler, _ := newLogHandler(f, cfg.Format, level)
+		handlers = append(handlers, fileHandler)
+		closer = f
+	}
+	slog.SetDefault(slog.New(handlers))
+	return closer, nil
+}
+
+// fatal 记录错误并退出，只在 main 中使用
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// multiHandler 将日志同时写到多个 handler，每个 handler 有自己的级别
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(multiHandler, len(m))
+	for i, h := range m {
+		result[i] = h.WithAttrs(attrs)
+	}
+	return result
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	result := make(multiHandler, len(m))
+	for i, h := range m {
+		result[i] = h.WithGroup(name)
+	}
+	return result
+}
+
+// rotatingFile 超过 maxSize 后将 path 改名为 path.1，旧的依次后移，最多保留 backups 个
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// 不能通过 slog 记录，否则会再次写入本文件
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", rf.path, err)
+		}
+		if rf.f == nil {
+			return 0, os.ErrClosed
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate 改名或创建新文件失败时重新打开原文件继续追加，写满 maxSize 后再重试，日志不会因为轮转失败而丢失。
+// 先把当前文件改为临时名称并创建新文件，都成功后才删除最旧的备份并依次后移，失败时已有的备份保持不变
+func (rf *rotatingFile) rotate() error {
+	// Windows 上打开的文件不能改名，先关闭
+	_ = rf.f.Close()
+	rf.f = nil
+	rotating := rf.path + ".rotating"
+	_ = os.Remove(rotating) // 上次轮转中途退出留下的文件
+	err := os.Rename(rf.path, rotating)
+	if err == nil {
+		if err = rf.open(); err == nil {
+			_ = os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+			for i := rf.backups - 1; i >= 1; i-- {
+				_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+			}
+			return os.Rename(rotating, rf.path+".1")
+		}
+		// 新文件创建失败，改回原文件名继续追加
+		_ = os.Rename(rotating, rf.path)
+	}
+	if openErr := rf.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	rf.size = 0
+	return err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+// formatLogLine 任务日志中的一行："[level] msg key=value ..."，不带时间（任务日志自带）
+func formatLogLine(level slog.Level, msg string, args ...any) string {
+	r := slog.NewRecord(time.Time{}, level, msg, 0)
+	r.Add(args...)
+	var sb strings.Builder
+	sb.WriteString("[" + strings.ToLower(level.String()) + "] " + msg)
+	r.Attrs(func(a slog.Attr) bool {
+		v := a.Value.Resolve().String()
+		if v == "" || strings.ContainsAny(v, " \"=\n") {
+			v = strconv.Quote(v)
+		}
+		sb.WriteString(" " + a.Key + "=" + v)
+		return true
+	})
+	return sb.String()
+}
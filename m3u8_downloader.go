@@ -3,39 +3,65 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 	"fmt"
 	"github.com/twmb/murmur3"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/levigross/grequests"
 )
 
 const (
 	// HEAD_TIMEOUT 请求头超时时间
 	HEAD_TIMEOUT = 10 * time.Second
+	// CONNECT_TIMEOUT 建立连接（含 TLS 握手）超时时间
+	CONNECT_TIMEOUT = 10 * time.Second
+	// BODY_IDLE_TIMEOUT 读取响应体时连续无数据的超时时间
+	BODY_IDLE_TIMEOUT = 30 * time.Second
+	// DOWNLOAD_WORKERS 下载 ts 的并发 worker 数
+	DOWNLOAD_WORKERS = 24
 )
 
 type TsInfo struct {
 	FileIndex   int
 	URLPrefix   string
 	URLLastName string // ts文件路径
+
+	Duration        float64   // #EXTINF 时长（秒）
+	Discontinuity   bool      // 前面有 #EXT-X-DISCONTINUITY
+	ProgramDateTime time.Time // #EXT-X-PROGRAM-DATE-TIME，没有时为零值
 }
 
 func (ts TsInfo) URL() string {
 	return fmt.Sprintf("%s/%s", ts.URLPrefix, ts.URLLastName)
 }
 
+// MediaDuration #EXTINF 时长，用于估算总大小和剩余时间
+func (ts TsInfo) MediaDuration() float64 {
+	return ts.Duration
+}
+
+// HostKey 用于按 host 限制并发连接数
+func (ts TsInfo) HostKey() string {
+	u, err := url.Parse(ts.URLPrefix)
+	if err != nil {
+		return ts.URLPrefix
+	}
+	return u.Host
+}
+
 type M3u8FileInfo struct {
 	Host       string
 	TsKey      string
@@ -43,44 +69,62 @@ type M3u8FileInfo struct {
 	FailTsList []TsInfo
 }
 
-func (mf *M3u8FileInfo) FetchM3u8Content(m3u8URL string, ro *grequests.RequestOptions) *grequests.Response {
-	r, err := grequests.Get(m3u8URL, ro)
+func (mf *M3u8FileInfo) FetchM3u8Content(m3u8URL string, ro *HttpOptions) (*http.Response, error) {
+	r, err := httpGet(m3u8URL, ro)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("%w %s: %w", ErrPlaylistFetch, m3u8URL, err)
 	}
-	return r
+	if r.StatusCode != http.StatusOK {
+		_ = r.Body.Close()
+		return nil, fmt.Errorf("%w %s: status code %d", ErrPlaylistFetch, m3u8URL, r.StatusCode)
+	}
+	return r, nil
 }
 
-func (mf *M3u8FileInfo) parseM3u8TsKey(data string) error {
+func (mf *M3u8FileInfo) parseM3u8TsKey(data string, ro *HttpOptions) error {
 	reg, _ := regexp.Compile(`#EXT-X-KEY.*URI="(.*?)"`)
 	tsKeyURLs := reg.FindStringSubmatch(data)
 	if len(tsKeyURLs) == 0 {
-		return fmt.Errorf("no ts key found in m3u8 content")
+		return fmt.Errorf("%w: no key URI in %q", ErrKeyFetch, data)
 	}
 	keyURL := tsKeyURLs[1]
 	if !strings.HasPrefix(keyURL, "http") {
 		// 如果没有http前缀，则拼接host
 		keyURL = fmt.Sprintf("%s/%s", mf.Host, keyURL)
 	}
-	res, err := grequests.Get(keyURL, NewHttpOptions(""))
+	statusCode, key, err := httpGetBytes(keyURL, ro)
 	if err != nil {
-		return err
+		metricKeyFailures.Inc(urlHost(keyURL))
+		return fmt.Errorf("%w %s: %w", ErrKeyFetch, keyURL, err)
 	}
 
-	if res.StatusCode != 200 {
-		return fmt.Errorf("failed to fetch ts key from %s, status code: %d", keyURL, res.StatusCode)
+	if statusCode != http.StatusOK {
+		metricKeyFailures.Inc(urlHost(keyURL))
+		return fmt.Errorf("%w %s: status code %d", ErrKeyFetch, keyURL, statusCode)
 	}
-	mf.TsKey = res.String()
+	mf.TsKey = string(key)
 	return nil
 }
 
-func (mf *M3u8FileInfo) ParseM3u8Content(m3u8URL string, ro *grequests.RequestOptions) error {
-	mf.Host = getHost(m3u8URL, "v1")
-	data := mf.FetchM3u8Content(m3u8URL, ro)
-	scanner := bufio.NewScanner(data)
+func (mf *M3u8FileInfo) ParseM3u8Content(m3u8URL string, ro *HttpOptions) error {
+	host, err := getHost(m3u8URL, "v1")
+	if err != nil {
+		return err
+	}
+	mf.Host = host
+	data, err := mf.FetchM3u8Content(m3u8URL, ro)
+	if err != nil {
+		return err
+	}
+	defer data.Body.Close()
+	scanner := bufio.NewScanner(data.Body)
 	i := 0
 	extInf, streamInf := false, false
 	streams := make([]string, 0)
+	// 作用于下一个分片的标签
+	var duration float64
+	var discontinuity bool
+	var programDateTime time.Time
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		// 多码率
@@ -94,22 +138,33 @@ func (mf *M3u8FileInfo) ParseM3u8Content(m3u8URL string, ro *grequests.RequestOp
 		} else if extInf {
 			// 兼容ts文件，存在ts/jpg/jpeg/m4s的情况
 			i++
-			ts := TsInfo{FileIndex: i, URLLastName: line, URLPrefix: mf.Host}
+			ts := TsInfo{FileIndex: i, URLLastName: line, URLPrefix: mf.Host,
+				Duration: duration, Discontinuity: discontinuity, ProgramDateTime: programDateTime}
+			duration, discontinuity, programDateTime = 0, false, time.Time{}
 			// ts 列表
 			if strings.HasPrefix(line, "http") {
-				ts.URLPrefix = getHost(line, "v1")
+				prefix, err := getHost(line, "v1")
+				if err != nil {
+					return err
+				}
+				ts.URLPrefix = prefix
 				ts.URLLastName = filepath.Base(line)
 			}
 			mf.TsList = append(mf.TsList, ts)
 			extInf = false
 		} else if strings.HasPrefix(line, "#EXT-X-KEY") && strings.Contains(line, "URI") {
 			// m3u8 key
-			err := mf.parseM3u8TsKey(line)
+			err := mf.parseM3u8TsKey(line, ro)
 			if err != nil {
 				return err
 			}
 		} else if strings.HasPrefix(line, "#EXTINF:") {
 			extInf = true
+			duration = parseExtInf(line)
+		} else if line == "#EXT-X-DISCONTINUITY" {
+			discontinuity = true
+		} else if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			programDateTime = parseProgramDateTime(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
 		} else if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
 			streamInf = true
 		} else if strings.HasPrefix(line, "#EXT-X-MAP") && strings.Contains(line, "URI") {
@@ -119,7 +174,7 @@ func (mf *M3u8FileInfo) ParseM3u8Content(m3u8URL string, ro *grequests.RequestOp
 			reg, _ := regexp.Compile(`#EXT-X-MAP.*URI="(.*?)"`)
 			tmp := reg.FindStringSubmatch(line)
 			if len(tmp) == 0 {
-				return fmt.Errorf("no init.mp4 found in m3u8 content")
+				return fmt.Errorf("%w %s: no init.mp4 found in #EXT-X-MAP", ErrPlaylistFetch, m3u8URL)
 			}
 			mf.TsList = append(mf.TsList, TsInfo{FileIndex: i, URLLastName: tmp[1], URLPrefix: mf.Host})
 		}
@@ -138,20 +193,48 @@ type M3u8Downloader struct {
 	m3u8Meta1    *M3u8FileInfo // m3u8文件信息
 	m3u8Meta2    *M3u8FileInfo // m3u8文件信息
 	bakM3u8URLCh chan string
-	doFailMu     sync.Mutex                // 处理失败的任务锁
-	ro           *grequests.RequestOptions // 请求选项
+	doFailMu     sync.Mutex   // 处理失败的任务锁
+	dispatchErr  error        // 无法分发到备用地址的分片，由 doFailMu 保护
+	ro           *HttpOptions // 请求选项
 	tsWriter     *TsWriter
+	coverFile    string // 临时目录中的封面，合并时嵌入 MP4
+	outputName   string // 相对 OutputPath 的输出路径，不含扩展名
+
+	OnProgress func(completed, total int) // 每个分片下载完成后调用，completed 包含之前已下载的分片
+	OnMerge    func()                     // 所有分片下载完成、开始合并时调用
+	OnLog      func(line string)          // 与该视频相关的日志，用于按任务查看日志
+	logger     *slog.Logger               // 带 video_id、site 属性
+	completed  int64
+	baseline   ProgressBaseline // DoDispatch 统计的之前已下载的分片
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
-func NewM3u8Downloader(videoMeta *VideoMeta, outputPath string, bakM3u8URLCh chan string) *M3u8Downloader {
-	tmpPath := fmt.Sprintf("%s%s", os.TempDir(), videoMeta.VideoID)
-	if _, err := os.Stat(tmpPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(tmpPath, 0755); err != nil {
-			log.Fatalf("Failed to create temporary directory: %v", err)
-		}
+// errDownloadStopped Stop 后 Download 返回该错误，已下载的分片保留在临时目录中
+var errDownloadStopped = errors.New("download stopped")
+
+// globalTempDir 分片临时目录，为空时使用系统临时目录。任务持久化时需要指定，避免重启前被系统清理
+var globalTempDir string
+
+// segmentDir 视频分片的临时目录
+func segmentDir(videoID string) string {
+	if globalTempDir != "" {
+		return filepath.Join(globalTempDir, videoID)
 	}
-	log.Println("Temporary directory created:", tmpPath)
+	return fmt.Sprintf("%s%s", os.TempDir(), videoID)
+}
+
+func NewM3u8Downloader(videoMeta *VideoMeta, outputPath string, bakM3u8URLCh chan string) (*M3u8Downloader, error) {
+	tmpPath := segmentDir(videoMeta.VideoID)
+	if err := os.MkdirAll(tmpPath, 0755); err != nil {
+		return nil, fmt.Errorf("create temporary directory: %w", err)
+	}
+	logger := slog.With("video_id", videoMeta.VideoID, "site", siteName(videoMeta.URL))
+	logger.Debug("Temporary directory created", "dir", tmpPath)
 	tsWriter := NewTsWriter(tmpPath)
+	tsWriter.logger = logger
+	ro := newVideoHttpOptions(videoMeta)
 	return &M3u8Downloader{
 		videoMeta:    videoMeta,
 		OutputPath:   outputPath,
@@ -160,18 +243,69 @@ func NewM3u8Downloader(videoMeta *VideoMeta, outputPath string, bakM3u8URLCh cha
 		m3u8Meta2:    &M3u8FileInfo{},
 		bakM3u8URLCh: bakM3u8URLCh,
 		doFailMu:     sync.Mutex{},
-		ro:           NewHttpOptions(videoMeta.M3u8URL),
+		ro:           ro,
 		tsWriter:     tsWriter,
+		outputName:   globalOutputNamer.Name(videoMeta, outputPath),
+		logger:       logger,
+		stopCh:       make(chan struct{}),
+	}, nil
+}
+
+// BudgetKey 同时下载多个视频时按视频公平分配 worker 名额
+func (md *M3u8Downloader) BudgetKey() string {
+	return md.videoMeta.VideoID
+}
+
+// ProgressBaseline 断点续传时临时目录中已下载的分片
+func (md *M3u8Downloader) ProgressBaseline() ProgressBaseline {
+	return md.baseline
+}
+
+// ProgressName 进度中显示视频标题
+func (md *M3u8Downloader) ProgressName() string {
+	return md.videoMeta.Title
+}
+
+// Stop 停止下载，正在下载的分片完成后 Download 返回 errDownloadStopped，合并开始后无法停止
+func (md *M3u8Downloader) Stop() {
+	md.stopOnce.Do(func() { close(md.stopCh) })
+}
+
+// log 输出带视频属性的日志，同时交给 OnLog
+func (md *M3u8Downloader) log(level slog.Level, msg string, args ...any) {
+	md.logger.Log(context.Background(), level, msg, args...)
+	if md.OnLog != nil {
+		md.OnLog(formatLogLine(level, msg, args...))
 	}
 }
 
+func (md *M3u8Downloader) stopped() bool {
+	select {
+	case <-md.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// basePath 输出文件路径（不含扩展名），封面、NFO 等 sidecar 文件与视频同名
+func (md *M3u8Downloader) basePath() string {
+	return filepath.Join(md.OutputPath, md.outputName)
+}
+
 func (md *M3u8Downloader) Download() error {
-	mvName := filepath.Join(md.OutputPath, md.videoMeta.Title+".mp4")
+	mvName := md.basePath() + ".mp4"
 	if _, err := os.Stat(mvName); err == nil {
-		log.Printf("[info] Video file %s already exists, skipping download\n", mvName)
+		md.log(slog.LevelInfo, "Video file already exists, skipping download", "path", mvName)
 		return nil
 	}
+	if err := os.MkdirAll(filepath.Dir(mvName), 0755); err != nil {
+		return err
+	}
 
+	if md.stopped() {
+		return errDownloadStopped
+	}
 	err := md.m3u8Meta1.ParseM3u8Content(md.videoMeta.M3u8URL, md.ro)
 	if err != nil {
 		return err
@@ -179,13 +313,41 @@ func (md *M3u8Downloader) Download() error {
 	go func() {
 		select {
 		case m3u8URL := <-md.bakM3u8URLCh:
-			log.Printf("[info] Received backup m3u8 URL: %s\n", m3u8URL)
+			md.log(slog.LevelInfo, "Received backup m3u8 URL", "m3u8", m3u8URL)
 			_ = md.m3u8Meta2.ParseM3u8Content(m3u8URL, md.ro)
 		}
 	}()
 
+	// 合并时需要嵌入封面，所以在下载分片前获取
+	cover, ext := md.fetchCover()
+	if cover != nil {
+		md.coverFile = filepath.Join(md.tmpPath, "cover"+ext)
+		if err := os.WriteFile(md.coverFile, cover, 0644); err != nil {
+			md.coverFile = ""
+		}
+	}
+
 	md.tsWriter.StartMerge()
-	ConcurrencyRun(md, 24)
+	workers := globalWorkerBudget.Capacity()
+	if workers <= 0 {
+		workers = DOWNLOAD_WORKERS
+	}
+	// 以 DoReduce 的结果为准：合并开始后再 Stop 不影响已经完成的合并
+	result := ConcurrencyRun(md, workers)
+	if err, ok := result.(error); ok {
+		if errors.Is(err, errDownloadStopped) {
+			// 没有合并，把缓冲中的分片写入临时目录，继续下载时跳过
+			md.tsWriter.Persist()
+		}
+		return err
+	}
+	if globalSidecarConfig.Enabled {
+		if err := md.writeSidecar(cover, ext); err != nil {
+			md.log(slog.LevelError, "Failed to write sidecar files", "error", err)
+		}
+	} else {
+		md.saveCover(cover, ext)
+	}
 	//	todo: 输出下载视频信息
 	return nil
 }
@@ -196,14 +358,21 @@ func (md *M3u8Downloader) DoDispatch() (<-chan MRTask, <-chan int) {
 
 	go func() {
 		total := 0
+		base := ProgressBaseline{Bytes: md.tsWriter.Size()}
 		for _, ts := range md.m3u8Meta1.TsList {
+			base.TotalSeconds += ts.Duration
 			if !md.tsWriter.CheckTsIsExist(ts.FileIndex) {
 				total++
+			} else {
+				base.Tasks++
+				base.Seconds += ts.Duration
 			}
 		}
+		md.baseline = base
+		atomic.StoreInt64(&md.completed, int64(len(md.m3u8Meta1.TsList)-total))
 		totalCh <- total
 		close(totalCh)
-		log.Printf("[info] Dispatch %d tasks for downloading ts files\n", total)
+		md.log(slog.LevelInfo, "Dispatching segment downloads", "segments", total)
 
 		// todo：动态调整速率
 		for _, ts := range md.m3u8Meta1.TsList {
@@ -215,14 +384,20 @@ func (md *M3u8Downloader) DoDispatch() (<-chan MRTask, <-chan int) {
 
 		md.doFailMu.Lock()
 		defer md.doFailMu.Unlock()
-		log.Printf("[info] Dispatched %d tasks for retrying failed ts files, secondary m3u8 key = %s \n", len(md.m3u8Meta1.FailTsList), md.m3u8Meta2.TsKey)
+		md.log(slog.LevelInfo, "Dispatching failed segments to the backup m3u8", "segments", len(md.m3u8Meta1.FailTsList), "backup_key", md.m3u8Meta2.TsKey != "")
 		if len(md.m3u8Meta1.FailTsList) == 0 || md.m3u8Meta2.TsKey == "" {
 			close(outCh)
 			return
 		}
 		for _, ts := range md.m3u8Meta1.FailTsList {
 			failTask := NewMRTask(ts, 5, "")
-			outCh <- *md.tryResetMRTask(&failTask)
+			task := md.tryResetMRTask(&failTask)
+			if task == nil {
+				// 已经是备用地址上的分片，无法再重试，DoReduce 中返回错误
+				md.dispatchErr = errors.Join(md.dispatchErr, fmt.Errorf("%w: segment %d failed on both m3u8 hosts", ErrSegmentFetch, ts.FileIndex))
+				continue
+			}
+			outCh <- *task
 		}
 		close(outCh)
 	}()
@@ -235,17 +410,29 @@ func (md *M3u8Downloader) DoMap(in MRTask) ([]interface{}, error) {
 	if in.extra != "" {
 		tsKey = in.extra
 	}
+	if md.stopped() {
+		// 剩余的任务直接结束，不再发起请求
+		return nil, errTaskCanceled
+	}
 	ts := in.data.(TsInfo)
-	err := md.downloadTs(ts, tsKey)
-	return nil, err
+	size, err := md.downloadTs(ts, tsKey)
+	if err != nil {
+		return nil, err
+	}
+	if md.OnProgress != nil {
+		md.OnProgress(int(atomic.AddInt64(&md.completed, 1)), len(md.m3u8Meta1.TsList))
+	}
+	return []interface{}{TaskBytes(size)}, nil
 }
 
 func (md *M3u8Downloader) tryResetMRTask(in *MRTask) *MRTask {
 	ts := in.data.(TsInfo)
 	if ts.URLPrefix == md.m3u8Meta1.Host {
 		ts.URLPrefix = md.m3u8Meta2.Host
+		in.data = ts
 		in.maxRetryCnt = 5
 		in.extra = md.m3u8Meta2.TsKey
+		metricFallbacks.Inc()
 		return in
 	} else {
 		// 两个cdn地址都找不到文件，放弃
@@ -254,6 +441,9 @@ func (md *M3u8Downloader) tryResetMRTask(in *MRTask) *MRTask {
 }
 
 func (md *M3u8Downloader) DoFail(in MRTask) *MRTask {
+	if md.stopped() {
+		return nil
+	}
 	md.doFailMu.Lock()
 	defer md.doFailMu.Unlock()
 
@@ -262,67 +452,112 @@ func (md *M3u8Downloader) DoFail(in MRTask) *MRTask {
 		return nil
 	}
 
-	return md.tryResetMRTask(&in)
+	task := md.tryResetMRTask(&in)
+	if task == nil {
+		// 备用地址上也失败了，DoReduce 中返回错误
+		ts := in.data.(TsInfo)
+		md.dispatchErr = errors.Join(md.dispatchErr, fmt.Errorf("%w: segment %d failed on both m3u8 hosts", ErrSegmentFetch, ts.FileIndex))
+	}
+	return task
 }
 
 func (md *M3u8Downloader) DoReduce(_ []interface{}) interface{} {
+	if md.stopped() {
+		return errDownloadStopped
+	}
+	md.doFailMu.Lock()
+	failErr := md.dispatchErr
+	if n := len(md.m3u8Meta1.FailTsList); n > 0 {
+		// 没有备用 m3u8 时，重试次数用完的分片留在 FailTsList 中
+		failErr = errors.Join(failErr, fmt.Errorf("%w: %d segments", ErrSegmentFetch, n))
+	}
+	md.doFailMu.Unlock()
+	if failErr != nil {
+		// 分片不完整，不合并，已下载的分片保留，重试时继续
+		md.tsWriter.Persist()
+		return failErr
+	}
+	if md.OnMerge != nil {
+		md.OnMerge()
+	}
 	mergeFilePath := md.tsWriter.Flush()
 	_, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		// .ts -> .mp4
 		// Merge: 多个 .ts 简单拼接（cat / io.Copy 合并，并不保证有合法的头部 / PAT/PMT 表
 		// 播放会存在卡顿问题，同时部分播放器无法播放。
-		_ = os.Rename(mergeFilePath, filepath.Join(md.OutputPath, md.videoMeta.Title+".mp4"))
-		log.Println("[warn] ffmpeg not found, using simple merge method")
+		md.log(slog.LevelWarn, "ffmpeg not found, using simple merge method")
+		if err := os.Rename(mergeFilePath, md.basePath()+".mp4"); err != nil {
+			return fmt.Errorf("%w: %w", ErrMerge, err)
+		}
 		return nil
 	}
-	log.Println("[info] ffmpeg found, using ffmpeg merge method")
-	md.FFmpegMerge(mergeFilePath)
+	md.log(slog.LevelInfo, "ffmpeg found, using ffmpeg merge method")
+	// 返回值作为 ConcurrencyRun 的结果，Download 中检查
+	if err := md.FFmpegMerge(mergeFilePath); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (md *M3u8Downloader) FFmpegMerge(mergeFile string) {
+// FFmpegMerge 失败时保留 mergeFile，返回 ErrMerge
+func (md *M3u8Downloader) FFmpegMerge(mergeFile string) error {
 	// todo: 参考https://github.com/orestonce/m3u8d/blob/main/merge.go去修改
-	baseName := filepath.Join(md.OutputPath, md.videoMeta.Title)
-	cmd := exec.Command("ffmpeg", "-i", mergeFile, "-c", "copy", baseName+".mp4")
+	baseName := md.basePath()
+	cmd := exec.Command("ffmpeg", md.ffmpegMergeArgs(mergeFile, baseName+".mp4")...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+	start := time.Now()
+	err := cmd.Run()
+	metricFFmpegMerge.ObserveSince(start)
+	if err != nil {
+		return fmt.Errorf("%w: ffmpeg: %w", ErrMerge, err)
 	}
 	_ = os.Remove(mergeFile)
+	if md.coverFile != "" {
+		_ = os.Remove(md.coverFile)
+	}
+	return nil
 }
 
-func (md *M3u8Downloader) downloadTs(ts TsInfo, tsKey string) error {
+func (md *M3u8Downloader) downloadTs(ts TsInfo, tsKey string) (size int64, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("[error] Panic occurred while downloading ts file:", ts, "Error:", r)
-			//fmt.Println("网络不稳定，正在进行断点持续下载")
+			md.log(slog.LevelError, "Panic occurred while downloading segment", "segment", ts.FileIndex, "url", ts.URL(), "error", r)
+			// 按普通失败处理：重试、切换备用地址或记为失败，不能当作下载成功
+			size, err = 0, fmt.Errorf("%w: panic while downloading segment %d: %v", retryError, ts.FileIndex, r)
 		}
 	}()
 
-	res, err := grequests.Get(ts.URL(), md.ro)
-	if err != nil || !res.Ok {
-		// todo: res.ok == false, need find why
-		log.Println("[error] Failed to download ts file:", ts, "Error:", err)
-		return retryError
+	host := ts.HostKey()
+	res, err := httpGet(ts.URL(), md.ro)
+	if err != nil {
+		metricSegments.Inc(host, "error")
+		md.log(slog.LevelWarn, "Failed to download segment", "segment", ts.FileIndex, "host", host, "error", err)
+		return 0, retryError
 	}
-	var origData []byte
-	origData = res.Bytes()
-	contentLen := 0
-	contentLenStr := res.Header.Get("Content-Length")
-	if contentLenStr != "" {
-		contentLen, _ = strconv.Atoi(contentLenStr)
+	defer res.Body.Close()
+	metricSegments.Inc(host, statusClass(res.StatusCode))
+	if res.StatusCode != http.StatusOK {
+		md.log(slog.LevelWarn, "Failed to download segment", "segment", ts.FileIndex, "host", host, "status", res.StatusCode)
+		return 0, retryError
 	}
-	if len(origData) == 0 || (contentLen > 0 && len(origData) < contentLen) || res.Error != nil {
-		log.Println("[error] Incomplete ts file or error occurred:", ts, "Error:", res.Error)
-		return retryError
+	// 流式读取，全局限速，所有 worker 共享带宽
+	buf := bytes.NewBuffer(make([]byte, 0, max(res.ContentLength, 0)))
+	_, err = io.Copy(buf, globalRateLimiter.Reader(res.Body))
+	origData := buf.Bytes()
+	// 统计网络传输的大小，与 Content-Length 一致
+	size = int64(len(origData))
+	// gzip 透明解压时 ContentLength 为 -1，不做长度校验
+	if err != nil || len(origData) == 0 || (res.ContentLength > 0 && int64(len(origData)) < res.ContentLength) {
+		md.log(slog.LevelWarn, "Incomplete segment", "segment", ts.FileIndex, "host", host, "bytes", len(origData), "content_length", res.ContentLength, "error", err)
+		return 0, retryError
 	}
 	if tsKey != "" {
 		//解密 ts 文件，算法：aes 128 cbc pack5
 		origData, err = AesDecrypt(origData, []byte(tsKey))
 		if err != nil {
-			return retryError
+			return 0, retryError
 		}
 		// https://en.wikipedia.org/wiki/MPEG_transport_stream
 		// Some TS files do not start with SyncByte 0x47, they can not be played after merging,
@@ -337,30 +572,31 @@ func (md *M3u8Downloader) downloadTs(ts TsInfo, tsKey string) error {
 		}
 	}
 
+	metricBytes.Add(float64(size), host)
 	md.tsWriter.WriteTs(ts.FileIndex, origData)
-	return nil
+	return size, nil
 }
 
-func NewHttpOptions(m3u8Url string) *grequests.RequestOptions {
-	ro := &grequests.RequestOptions{
-		UserAgent:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.88 Safari/537.36",
-		RequestTimeout: HEAD_TIMEOUT,
-		Headers: map[string]string{
-			"Connection":      "keep-alive",
-			"Accept":          "*/*",
-			"Accept-Encoding": "*",
-			"Accept-Language": "zh-CN,zh;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5",
+func NewHttpOptions(m3u8Url string) *HttpOptions {
+	ro := &HttpOptions{
+		Headers: http.Header{
+			"User-Agent":      {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.88 Safari/537.36"},
+			"Accept":          {"*/*"},
+			"Accept-Language": {"zh-CN,zh;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"},
 		},
 	}
-	ro.Headers["Referer"] = getHost(m3u8Url, "v2")
+	// 地址不合法时 ParseM3u8Content 会返回 ErrInvalidURL
+	if referer, err := getHost(m3u8Url, "v2"); err == nil {
+		ro.Headers.Set("Referer", referer)
+	}
 	return ro
 }
 
 // 获取m3u8地址的host
-func getHost(Url, ht string) (host string) {
+func getHost(Url, ht string) (host string, err error) {
 	u, err := url.Parse(Url)
 	if err != nil {
-		log.Panic(err)
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, err)
 	}
 	switch ht {
 	case "v1":
@@ -368,7 +604,7 @@ func getHost(Url, ht string) (host string) {
 	case "v2":
 		host = u.Scheme + "://" + u.Host
 	}
-	return
+	return host, nil
 }
 
 // ============================== 加解密相关 ==============================
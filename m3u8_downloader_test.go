@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDownloader(t *testing.T) *M3u8Downloader {
+	t.Helper()
+	dir := t.TempDir()
+	md := &M3u8Downloader{
+		tmpPath:   dir,
+		tsWriter:  NewTsWriter(dir),
+		m3u8Meta1: &M3u8FileInfo{Host: "https://a.test"},
+		m3u8Meta2: &M3u8FileInfo{},
+		stopCh:    make(chan struct{}),
+		logger:    slog.Default(),
+	}
+	md.tsWriter.StartMerge()
+	return md
+}
+
+func TestDoReduceFailsOnSegmentsWithoutBackup(t *testing.T) {
+	md := newTestDownloader(t)
+	md.tsWriter.WriteTs(0, []byte("ts"))
+	// 没有备用 m3u8，重试次数用完的分片进入 FailTsList
+	if task := md.DoFail(NewMRTask(TsInfo{FileIndex: 1, URLPrefix: "https://a.test"}, 0, "")); task != nil {
+		t.Fatalf("DoFail without backup = %+v, want nil", task)
+	}
+	err, _ := md.DoReduce(nil).(error)
+	if !errors.Is(err, ErrSegmentFetch) {
+		t.Fatalf("DoReduce = %v, want ErrSegmentFetch", err)
+	}
+	if _, err := os.Stat(filepath.Join(md.tmpPath, "merge.ts")); !os.IsNotExist(err) {
+		t.Errorf("incomplete video was merged")
+	}
+	if _, err := os.Stat(filepath.Join(md.tmpPath, "0_1.ts")); err != nil {
+		t.Errorf("downloaded segment not persisted: %v", err)
+	}
+}
+
+func TestDoFailReportsSegmentsFailedOnBothHosts(t *testing.T) {
+	md := newTestDownloader(t)
+	md.m3u8Meta2 = &M3u8FileInfo{Host: "https://b.test", TsKey: "0123456789abcdef"}
+	task := md.DoFail(NewMRTask(TsInfo{FileIndex: 1, URLPrefix: "https://a.test"}, 0, ""))
+	if task == nil || task.data.(TsInfo).URLPrefix != "https://b.test" {
+		t.Fatalf("DoFail on the first host = %+v, want a task on the backup host", task)
+	}
+	if again := md.DoFail(*task); again != nil {
+		t.Fatalf("DoFail on the backup host = %+v, want nil", again)
+	}
+	if err, _ := md.DoReduce(nil).(error); !errors.Is(err, ErrSegmentFetch) {
+		t.Errorf("DoReduce = %v, want ErrSegmentFetch", err)
+	}
+}
+
+func TestDownloadTsReturnsErrorOnPanic(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不是 AES 块大小的整数倍，解密时 CryptBlocks 会 panic
+		_, _ = w.Write([]byte("truncated"))
+	}))
+	defer srv.Close()
+	md := newTestDownloader(t)
+	md.ro = &HttpOptions{}
+	size, err := md.downloadTs(TsInfo{FileIndex: 0, URLPrefix: srv.URL, URLLastName: "0.ts"}, "0123456789abcdef")
+	if !errors.Is(err, retryError) || size != 0 {
+		t.Errorf("downloadTs = %d, %v, want a retry error", size, err)
+	}
+}
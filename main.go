@@ -1,34 +1,262 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"runtime"
 	"strings"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: m3u8downloader <video_page_url or filepath>")
+	configPath := flag.String("config", "", "config file (JSON), reloaded on SIGHUP")
+	limitRate := flag.String("limit-rate", "", "global download rate limit, e.g. 500K, 2M")
+	hostConns := flag.Int("host-conns", 0, "max concurrent connections per host (0 = unlimited)")
+	workers := flag.Int("workers", 0, "total segment download workers shared by all videos (default 24)")
+	jobs := flag.Int("jobs", 0, "number of videos downloaded at the same time (default 1)")
+	cookies := flag.String("cookies", "", "Netscape format cookies file used for playlist, key and segment requests")
+	var headers stringsFlag
+	flag.Var(&headers, "header", "extra request header \"Name: value\", can be repeated")
+	sitesFile := flag.String("sites", "", "site definitions file (JSON)")
+	browsers := flag.Int("browsers", 1, "number of headless Chrome instances kept alive for metadata fetching")
+	proxy := flag.String("proxy", "", "proxy for all requests: http://, https://, socks5://[user:pass@]host:port, or \"direct\"")
+	output := flag.String("o", "", "output path template, e.g. \"{site}/{id} - {title}.{ext}\" (fields: title id code videoid site studio actor actors date year ext)")
+	filenameMode := flag.String("filename-mode", "", "filename sanitization: portable, windows or posix (default portable, windows on Windows)")
+	jobsDB := flag.String("db", "", "job state database, re-running resumes unfinished jobs")
+	tempDir := flag.String("temp-dir", "", "directory for downloaded segments (default system temp dir, <db>.parts with -db)")
+	listen := flag.String("listen", "", "serve mode listen address (default "+DEFAULT_LISTEN+")")
+	archive := flag.String("archive", "", "download archive file, videos recorded in it are skipped")
+	nfo := flag.Bool("nfo", false, "write Kodi/Jellyfin .nfo and poster/fanart next to each video")
+	progress := flag.String("progress", "", "progress output: auto, tty (multi-bar), log, json (JSON lines on stdout) or none (default auto)")
+	logLevel := flag.String("log-level", "", "log level: debug, info, warn or error (default info)")
+	logFormat := flag.String("log-format", "", "log format: text or json (default text)")
+	logFile := flag.String("log-file", "", "also write logs to this file, rotated at 100M keeping 5 old files")
+	quiet := flag.Bool("quiet", false, "only print errors to the terminal and disable progress output unless -progress is set, -log-file is unaffected")
+	fixtures := flag.String("fixtures", "testdata/sites", "directory of recorded site fixtures used by selftest and record")
+	flag.Usage = func() {
+		fmt.Println("Usage: m3u8downloader [options] <video_page_url or filepath>")
+		fmt.Println("       m3u8downloader [options] list-sites [url ...]")
+		fmt.Println("       m3u8downloader [options] selftest [fixture ...]")
+		fmt.Println("       m3u8downloader -db jobs.db resume|jobs")
+		fmt.Println("       m3u8downloader [-db jobs.db] [-listen addr] serve")
+		fmt.Println("       m3u8downloader -archive file archive list|import <file>...|prune [-older-than d] [-missing] [-site s] [url|site id ...]")
+		fmt.Println("       m3u8downloader [options] record <fixture> <video_page_url>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		return
 	}
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	master := NewDLMaster()
-	master.RegisterVideoHandle("https://jable.tv/", FetchJableTVVideoMeta)
-	master.RegisterVideoHandle("https://hohoj.tv/", FetchHohojTVVideoMeta)
-	master.RegisterVideoHandle("https://missav.ai/", FetchMissavAiVideoMeta)
-	master.RegisterVideoHandle("https://memojav.com/", FetchMemojavVideoMeta)
-	master.RegisterVideoHandle("https://avtoday.io/", FetchAVTodayIOVideoMeta)
-	master.RegisterVideoHandle("https://netflav.com/", FetchNetflAVVideoMeta)
-	master.RegisterVideoHandle("https://f15.bzraizy.cc/", FetchBzraizyVideoMeta)
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fatal("Failed to load config", "error", err)
+	}
+	if *progress != "" {
+		cfg.Progress = *progress
+	}
+	if *quiet {
+		cfg.Log.Quiet = true
+	}
+	if cfg.Log.Quiet && cfg.Progress == "" {
+		cfg.Progress = progressModeNone
+	}
+	stdout := os.Stdout
+	if cfg.Progress == progressModeJSON {
+		// stdout 只输出 JSON，其他输出改到 stderr
+		os.Stdout = os.Stderr
+	}
+	reporter, err := NewProgressReporter(cfg.Progress, stdout, os.Stderr)
+	if err != nil {
+		fatal("Invalid progress mode", "error", err)
+	}
+	globalProgressReporter = reporter
+	var console io.Writer = os.Stderr
+	if tty, ok := reporter.(*TTYReporter); ok {
+		// 日志输出在进度条上方
+		console = tty
+	}
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
+	}
+	if *logFormat != "" {
+		cfg.Log.Format = *logFormat
+	}
+	if *logFile != "" {
+		cfg.Log.File = *logFile
+	}
+	logCloser, err := SetupLogging(cfg.Log, console)
+	if err != nil {
+		fatal("Invalid log options", "error", err)
+	}
+	defer logCloser.Close()
+	limitOverrides := LimitOverrides{LimitRate: *limitRate, HostConns: *hostConns, Workers: *workers}
+	limitOverrides.Apply(cfg)
+	if *jobs > 0 {
+		cfg.Jobs = *jobs
+	}
+	if err := cfg.ApplyLimits(); err != nil {
+		fatal("Invalid limits", "error", err)
+	}
+	WatchConfigReload(*configPath, limitOverrides)
+	if *proxy != "" {
+		cfg.Proxy.Default = *proxy
+	}
+	globalProxyConfig = &cfg.Proxy
+	if *cookies != "" {
+		cfg.Cookies = *cookies
+	}
+	overrides, err := cfg.RequestOverrides(headers)
+	if err != nil {
+		fatal("Invalid headers or cookies", "error", err)
+	}
+	globalRequestOverrides = overrides
+	if *nfo {
+		cfg.Sidecar.Enabled = true
+	}
+	if *output != "" {
+		cfg.OutputTemplate = *output
+	}
+	if *filenameMode != "" {
+		cfg.FilenameMode = *filenameMode
+	}
+	namer, err := NewOutputNamer(cfg.OutputTemplate, cfg.FilenameMode)
+	if err != nil {
+		fatal("Invalid output template", "error", err)
+	}
+	globalOutputNamer = namer
+	if cfg.Sidecar.Enabled {
+		// 启动时校验模板和目录布局，避免下载完成后才发现错误
+		if err := cfg.Sidecar.Validate(namer); err != nil {
+			fatal("Invalid sidecar config", "error", err)
+		}
+	}
+	globalSidecarConfig = &cfg.Sidecar
+
+	master := NewDLMaster(*browsers)
+	defer master.Close()
+	master.SetJobs(cfg.Jobs)
+	if err := master.RegisterBuiltinSites(); err != nil {
+		fatal("Invalid site", "error", err)
+	}
+	for alias, canonical := range cfg.HostAliases {
+		master.RegisterHostAlias(alias, canonical)
+	}
+	if err := master.RegisterSiteDefinitions(cfg.Sites); err != nil {
+		fatal("Invalid site definitions", "error", err)
+	}
+	if *sitesFile != "" {
+		cfg.SitesFile = *sitesFile
+	}
+	if cfg.SitesFile != "" {
+		if err := master.LoadSiteDefinitions(cfg.SitesFile); err != nil {
+			fatal("Failed to load site definitions", "error", err)
+		}
+	}
+
+	if *archive != "" {
+		cfg.Archive = *archive
+	}
+	if cfg.Archive != "" {
+		da, err := OpenDownloadArchive(cfg.Archive)
+		if err != nil {
+			fatal("Failed to open download archive", "error", err)
+		}
+		master.SetArchive(da)
+		globalOutputNamer.SetArchive(da)
+	}
+	if *jobsDB != "" {
+		cfg.JobsDB = *jobsDB
+	}
+	if flag.Arg(0) == "serve" && cfg.JobsDB == "" {
+		// serve 模式的任务必须持久化
+		cfg.JobsDB = "jobs.db"
+	}
+	if *tempDir != "" {
+		cfg.TempDir = *tempDir
+	}
+	if cfg.TempDir == "" && cfg.JobsDB != "" {
+		cfg.TempDir = cfg.JobsDB + ".parts"
+	}
+	globalTempDir = cfg.TempDir
+	if cfg.JobsDB != "" {
+		store, err := OpenJobStore(cfg.JobsDB)
+		if err != nil {
+			fatal("Failed to open job store", "error", err)
+		}
+		defer store.Close()
+		master.SetJobStore(store)
+	}
+	if flag.Arg(0) == "jobs" || flag.Arg(0) == "resume" {
+		if master.store == nil {
+			fatal("Command requires -db or \"jobs_db\" in config", "command", flag.Arg(0))
+		}
+		if flag.Arg(0) == "jobs" {
+			_ = master.store.Print(os.Stdout)
+			return
+		}
+		// resume: 只继续数据库中未完成的任务
+		master.Run()
+		return
+	}
+	if flag.Arg(0) == "serve" {
+		if *listen != "" {
+			cfg.Listen = *listen
+		}
+		if cfg.Listen == "" {
+			cfg.Listen = DEFAULT_LISTEN
+		}
+		if err := runServer(master, cfg.Listen); err != nil {
+			slog.Error("Server stopped", "error", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "archive" {
+		if master.archive == nil {
+			fatal("archive command requires -archive or \"archive\" in config")
+		}
+		if err := runArchiveCommand(master.archive, flag.Args()[1:], os.Stdout); err != nil {
+			fatal("Archive command failed", "error", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "list-sites" {
+		master.ListSites(os.Stdout, flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "selftest" {
+		failed := master.RunSelfTest(*fixtures, flag.Args()[1:])
+		master.Close()
+		if failed > 0 {
+			fmt.Printf("%d fixture(s) failed\n", failed)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "record" {
+		if flag.NArg() != 3 {
+			flag.Usage()
+			return
+		}
+		if err := master.RecordFixture(*fixtures, flag.Arg(1), flag.Arg(2)); err != nil {
+			slog.Error("Failed to record fixture", "error", err)
+		}
+		return
+	}
 
-	videoURL := os.Args[1]
+	videoURL := flag.Arg(0)
 	if strings.HasPrefix(videoURL, "http") {
 		master.videoURLs = append(master.videoURLs, videoURL)
 	} else {
-		videoURLs := loadURLs(videoURL)
+		videoURLs, err := loadURLs(videoURL)
+		if err != nil {
+			fatal("Failed to load URL list", "error", err)
+		}
 		master.videoURLs = videoURLs
 	}
 
@@ -2,10 +2,7 @@ package main
 
 import (
 	"errors"
-	"github.com/schollz/progressbar/v3"
-	"log"
 	"sync"
-	"time"
 )
 
 /*
@@ -22,6 +19,37 @@ type MapReduce interface {
 
 var retryError = errors.New("retry Task")
 
+// errTaskCanceled DoMap 返回该错误时任务直接结束，不调用 DoFail，也不计为失败
+var errTaskCanceled = errors.New("task canceled")
+
+// hostKeyer 任务数据实现该接口时，worker 会按 host 限制并发连接数
+type hostKeyer interface {
+	HostKey() string
+}
+
+// budgetKeyer MapReduce 实现该接口时，worker 执行任务前从全局 worker 名额中按 BudgetKey 公平分配
+type budgetKeyer interface {
+	BudgetKey() string
+}
+
+// progressNamer MapReduce 实现该接口时，进度事件中使用该名称（例如视频标题）
+type progressNamer interface {
+	ProgressName() string
+}
+
+// progressBaseliner MapReduce 实现该接口时，进度包含之前已完成的部分。DoDispatch 返回任务数之后调用
+type progressBaseliner interface {
+	ProgressBaseline() ProgressBaseline
+}
+
+// mediaDurationer 任务数据实现该接口时，进度按视频时长估算总大小和剩余时间
+type mediaDurationer interface {
+	MediaDuration() float64
+}
+
+// TaskBytes DoMap 返回的 outs 中的 TaskBytes 计入下载字节数，不会传给 DoReduce
+type TaskBytes int64
+
 type MRTask struct {
 	maxRetryCnt int
 	extra       string
@@ -42,8 +70,14 @@ func ConcurrencyRun(mr MapReduce, workCnt int) interface{} {
 	retryCh := make(chan MRTask, 128)
 	wg, wgTask := &sync.WaitGroup{}, &sync.WaitGroup{}
 
-	pb := progressbar.New(-1)
-	now := time.Now()
+	key, name := "", ""
+	if bk, ok := mr.(budgetKeyer); ok {
+		key = bk.BudgetKey()
+	}
+	if pn, ok := mr.(progressNamer); ok {
+		name = pn.ProgressName()
+	}
+	progress := newProgressTracker(globalProgressReporter, key, firstNonEmpty(name, key))
 	// 分配任务
 	inCh, outTotal := mr.DoDispatch()
 
@@ -53,27 +87,59 @@ func ConcurrencyRun(mr MapReduce, workCnt int) interface{} {
 		go func() {
 			handleFn := func(in MRTask) {
 				defer wgTask.Done()
+				// 先占 host 连接再占全局名额，等待繁忙 host 的 worker 不会占着名额让其他 host 的视频饿死
+				if hk, ok := in.data.(hostKeyer); ok {
+					host := hk.HostKey()
+					globalHostLimiter.Acquire(host)
+					defer globalHostLimiter.Release(host)
+				}
+				if bk, ok := mr.(budgetKeyer); ok {
+					globalWorkerBudget.Acquire(bk.BudgetKey())
+					defer globalWorkerBudget.Release()
+				}
+				metricActiveWorkers.Add(1)
+				defer metricActiveWorkers.Add(-1)
 				outs, err := mr.DoMap(in)
+				if errors.Is(err, errTaskCanceled) {
+					return
+				}
 				if err != nil {
 					if errors.Is(err, retryError) && in.maxRetryCnt > 0 {
 						in.maxRetryCnt--
+						progress.Retry(err)
+						metricRetries.Inc()
 						wgTask.Add(1)
 						retryCh <- in // 重新放回任务队列
 						return
 					}
 					newMRTask := mr.DoFail(in) // 处理失败任务
 					if newMRTask != nil {
+						progress.Retry(err)
 						wgTask.Add(1)
 						retryCh <- *newMRTask // 重新放回任务队列
+						return
 					}
+					progress.Fail(err)
+					metricFailures.Inc()
 					return
 				}
-				_ = pb.Add(1)
+				var bytes int64
 				for _, out := range outs {
+					if n, ok := out.(TaskBytes); ok {
+						bytes += int64(n)
+						continue
+					}
 					outCh <- out
 				}
+				var seconds float64
+				if md, ok := in.data.(mediaDurationer); ok {
+					seconds = md.MediaDuration()
+				}
+				progress.TaskDone(bytes, seconds)
 			}
 			defer wg.Done()
+			// 每个 worker 使用自己的 channel 变量，关闭后置为 nil 不影响其他 worker
+			inCh, retryCh := inCh, retryCh
 			for {
 				select {
 				case in, ok := <-inCh:
@@ -100,7 +166,11 @@ func ConcurrencyRun(mr MapReduce, workCnt int) interface{} {
 	go func() {
 		select {
 		case t := <-outTotal:
-			pb.ChangeMax(t)
+			var base ProgressBaseline
+			if pb, ok := mr.(progressBaseliner); ok {
+				base = pb.ProgressBaseline()
+			}
+			progress.Start(t, base)
 			wgTask.Add(t)
 		}
 		wgTask.Wait()
@@ -116,8 +186,6 @@ func ConcurrencyRun(mr MapReduce, workCnt int) interface{} {
 	}
 
 	// 最终归约结果
-	reduceResult := mr.DoReduce(results)
-	log.Println()
-	log.Printf("🎯 All tasks completed in %s\n", time.Since(now))
-	return reduceResult
+	progress.Finish()
+	return mr.DoReduce(results)
 }
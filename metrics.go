@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus 文本格式的指标，只实现用到的 counter、gauge、histogram
+
+var (
+	metricSegments = newCounterVec("m3u8dl_segment_downloads_total",
+		"Segment download attempts by host and HTTP status class (2xx, 4xx, 5xx, error).", "host", "status")
+	metricBytes = newCounterVec("m3u8dl_downloaded_bytes_total",
+		"Bytes of segments downloaded successfully.", "host")
+	metricRetries = newCounterVec("m3u8dl_segment_retries_total",
+		"Segment retries on the same host.")
+	metricFallbacks = newCounterVec("m3u8dl_segment_fallbacks_total",
+		"Segments switched to the backup playlist mirror after failing on the primary one.")
+	metricFailures = newCounterVec("m3u8dl_segment_failures_total",
+		"Segments given up after all retries and fallbacks.")
+	metricKeyFailures = newCounterVec("m3u8dl_key_fetch_failures_total",
+		"Failed EXT-X-KEY fetches by host.", "host")
+	metricActiveWorkers = newGauge("m3u8dl_active_workers",
+		"Segment workers currently downloading.")
+	metricTsBuffer = newGauge("m3u8dl_tswriter_buffer_bytes",
+		"Segment bytes buffered in memory by TsWriter before being written to disk.")
+	metricTsMerge = newHistogramVec("m3u8dl_tswriter_merge_duration_seconds",
+		"TsWriter merge durations: buffer flushes to part files and the final concatenation.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}, "stage")
+	metricFFmpegMerge = newHistogramVec("m3u8dl_ffmpeg_merge_duration_seconds",
+		"ffmpeg remux durations.", []float64{1, 5, 10, 30, 60, 120, 300, 600})
+	metricMetaFetch = newHistogramVec("m3u8dl_metadata_fetch_duration_seconds",
+		"Video metadata fetch latency by site handler.", []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60}, "site")
+	metricMetaFetchResults = newCounterVec("m3u8dl_metadata_fetch_total",
+		"Video metadata fetches by site handler and result (success, failure).", "site", "result")
+)
+
+// allMetrics 按 /metrics 中的输出顺序排列
+var allMetrics = []metricWriter{
+	metricSegments, metricBytes, metricRetries, metricFallbacks, metricFailures, metricKeyFailures,
+	metricActiveWorkers, metricTsBuffer, metricTsMerge, metricFFmpegMerge, metricMetaFetch, metricMetaFetchResults,
+}
+
+type metricWriter interface {
+	writeTo(w io.Writer)
+}
+
+// counterVec 带标签的 counter，没有标签时 labels 为空
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // key 为 labelKey
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatMetricValue(c.values[key]))
+	}
+}
+
+// gauge 没有标签的 gauge
+type gauge struct {
+	name, help string
+	bits       atomic.Uint64
+}
+
+func newGauge(name, help string) *gauge {
+	return &gauge{name: name, help: help}
+}
+
+func (g *gauge) Add(v float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (g *gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *gauge) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatMetricValue(g.Value()))
+}
+
+// histogramVec 带标签的 histogram，buckets 为升序的上界
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // 每个 bucket 的数量（非累计），最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+// ObserveSince 记录从 start 开始的秒数
+func (h *histogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatMetricValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// labelKey 生成 {name="value",...}，同时作为 map 的 key
+func labelKey(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(v))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// withLabel 在 labelKey 的结果中追加一个标签
+func withLabel(key, name, value string) string {
+	label := name + `="` + escapeLabelValue(value) + `"`
+	if key == "" {
+		return "{" + label + "}"
+	}
+	return key[:len(key)-1] + "," + label + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statusClass 200 -> "2xx"
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// handleMetrics GET /metrics，serve 模式下同时输出各状态的任务数
+func (dm *DLMaster) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range allMetrics {
+		m.writeTo(w)
+	}
+	if dm.store == nil {
+		return
+	}
+	jobs, err := dm.store.List()
+	if err != nil {
+		return
+	}
+	counts := make(map[string]float64)
+	for _, state := range []JobState{JobPending, JobFetchingMeta, JobDownloading, JobMerging, JobDone, JobFailed, JobPaused, JobCanceled} {
+		counts[labelKey([]string{"state"}, []string{string(state)})] = 0
+	}
+	for _, job := range jobs {
+		counts[labelKey([]string{"state"}, []string{string(job.State)})]++
+	}
+	fmt.Fprint(w, "# HELP m3u8dl_jobs Jobs in the job store by state.\n# TYPE m3u8dl_jobs gauge\n")
+	for _, key := range sortedKeys(counts) {
+		fmt.Fprintf(w, "m3u8dl_jobs%s %s\n", key, formatMetricValue(counts[key]))
+	}
+}
+
+// urlHost 用作指标标签的 host，解析失败时返回原值
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
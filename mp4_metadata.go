@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CHAPTER_MIN_GAP 相邻分片的 EXT-X-PROGRAM-DATE-TIME 相差超过该值时视为新的章节
+const CHAPTER_MIN_GAP = 2 * time.Second
+
+// Chapter 输出 MP4 中的章节，Start/End 为相对视频开头的偏移
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// parseExtInf "#EXTINF:10.010," -> 10.01
+func parseExtInf(line string) float64 {
+	v := strings.TrimPrefix(line, "#EXTINF:")
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	d, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	return d
+}
+
+func parseProgramDateTime(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// buildChapters 在 #EXT-X-DISCONTINUITY 处，或 EXT-X-PROGRAM-DATE-TIME 不连续处划分章节，只有一个章节时返回 nil
+func buildChapters(tsList []TsInfo) []Chapter {
+	var chapters []Chapter
+	var offset time.Duration
+	var expected time.Time // 按上一个分片推算的本分片开始时间
+	for _, ts := range tsList {
+		if ts.Duration <= 0 {
+			// EXT-X-MAP 初始化分片
+			continue
+		}
+		start := len(chapters) == 0 || ts.Discontinuity
+		if !ts.ProgramDateTime.IsZero() && !expected.IsZero() {
+			gap := ts.ProgramDateTime.Sub(expected)
+			if gap > CHAPTER_MIN_GAP || gap < -CHAPTER_MIN_GAP {
+				start = true
+			}
+		}
+		if start {
+			if n := len(chapters); n > 0 {
+				chapters[n-1].End = offset
+			}
+			title := fmt.Sprintf("Chapter %d", len(chapters)+1)
+			if !ts.ProgramDateTime.IsZero() {
+				title = ts.ProgramDateTime.Local().Format("2006-01-02 15:04:05")
+			}
+			chapters = append(chapters, Chapter{Title: title, Start: offset})
+		}
+		segment := time.Duration(ts.Duration * float64(time.Second))
+		offset += segment
+		if !ts.ProgramDateTime.IsZero() {
+			expected = ts.ProgramDateTime.Add(segment)
+		} else if !expected.IsZero() {
+			expected = expected.Add(segment)
+		}
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	chapters[len(chapters)-1].End = offset
+	return chapters
+}
+
+// writeFFMetadata 生成 ffmpeg 的 FFMETADATA 文件，mp4 muxer 会将这些字段写入 moov/udta/meta 的 iTunes 标签。
+// muxer 不认识的字段会被丢弃（除非使用 -movflags use_metadata_tags，但那样会改用 mdta 格式，©cmt 等标签不再写入），
+// 因此只写 muxer 支持的字段，番号和标签分别保存在 NFO 和 genre 中
+func writeFFMetadata(path string, meta *VideoMeta, chapters []Chapter) error {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	tags := [][2]string{
+		{"title", meta.Title},
+		{"description", meta.Description},
+		{"synopsis", meta.Description},
+		{"artist", strings.Join(meta.Actors, ", ")},
+		{"album_artist", meta.Studio},
+		{"date", meta.ReleaseDate},
+		{"genre", strings.Join(meta.Tags, ", ")},
+		{"comment", meta.URL},
+	}
+	for _, tag := range tags {
+		if tag[1] != "" {
+			fmt.Fprintf(&sb, "%s=%s\n", tag[0], escapeFFMetadata(tag[1]))
+		}
+	}
+	for _, c := range chapters {
+		fmt.Fprintf(&sb, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			c.Start.Milliseconds(), c.End.Milliseconds(), escapeFFMetadata(c.Title))
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// escapeFFMetadata '='、';'、'#'、'\' 和换行需要转义
+func escapeFFMetadata(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			sb.WriteByte('\\')
+		case '\r':
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// ffmpegMergeArgs 合并时同时写入元数据、章节和封面，只有 jpg/png 可以作为 MP4 封面
+func (md *M3u8Downloader) ffmpegMergeArgs(mergeFile, output string) []string {
+	args := []string{"-i", mergeFile}
+	metaFile := filepath.Join(md.tmpPath, "ffmetadata.txt")
+	chapters := buildChapters(md.m3u8Meta1.TsList)
+	hasMeta := writeFFMetadata(metaFile, md.videoMeta, chapters) == nil
+	if hasMeta {
+		args = append(args, "-i", metaFile)
+	}
+	hasCover := md.coverFile != "" && (strings.HasSuffix(md.coverFile, ".jpg") || strings.HasSuffix(md.coverFile, ".png"))
+	if hasCover {
+		args = append(args, "-i", md.coverFile)
+	}
+	args = append(args, "-map", "0:v?", "-map", "0:a?")
+	if hasMeta {
+		args = append(args, "-map_metadata", "1", "-map_chapters", "1")
+	}
+	if hasCover {
+		coverInput := "1"
+		if hasMeta {
+			coverInput = "2"
+		}
+		// 分片中只有一路视频，封面是第二路
+		args = append(args, "-map", coverInput, "-disposition:v:1", "attached_pic")
+	}
+	return append(args, "-c", "copy", output)
+}
+
+// readMP4Comment 读取合并时写入 moov/udta/meta/ilst/©cmt 的来源地址
+func readMP4Comment(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	start, end := int64(0), fi.Size()
+	for _, typ := range []string{"moov", "udta", "meta", "ilst", "\xa9cmt", "data"} {
+		if start, end, err = findMP4Box(f, start, end, typ); err != nil {
+			return "", err
+		}
+		switch typ {
+		case "meta":
+			start += 4 // version + flags
+		case "data":
+			start += 8 // 类型 + locale
+		}
+	}
+	if end-start <= 0 || end-start > 64*1024 {
+		return "", fmt.Errorf("invalid comment size %d", end-start)
+	}
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// findMP4Box 在 [start, end) 中查找类型为 typ 的 box，返回其内容的范围
+func findMP4Box(r io.ReaderAt, start, end int64, typ string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return 0, 0, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return 0, 0, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerSize || pos+size > end {
+			return 0, 0, fmt.Errorf("invalid mp4 box %q at %d", header[4:8], pos)
+		}
+		if string(header[4:8]) == typ {
+			return pos + headerSize, pos + size, nil
+		}
+		pos += size
+	}
+	return 0, 0, fmt.Errorf("mp4 box %q not found", typ)
+}
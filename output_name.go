@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DEFAULT_OUTPUT_TEMPLATE 与之前的命名方式相同
+	DEFAULT_OUTPUT_TEMPLATE = "{title}.{ext}"
+	// MAX_NAME_BYTES 单个文件/目录名的最大字节数（NAME_MAX 为 255），为 -fanart.webp、" (99)" 等后缀预留空间
+	MAX_NAME_BYTES = 255 - 24
+)
+
+const (
+	filenameModePortable = "portable" // Windows 规则，同时去掉 emoji 和控制字符，适用于 SMB/exFAT/NAS
+	filenameModeWindows  = "windows"  // <>:"/\|?* 、保留名（CON、NUL 等）、结尾的点和空格
+	filenameModePosix    = "posix"    // 只替换 / 和 NUL
+)
+
+var outputFields = map[string]func(meta *VideoMeta) string{
+	"title":   func(meta *VideoMeta) string { return meta.Title },
+	"id":      func(meta *VideoMeta) string { return firstNonEmpty(meta.Code, meta.VideoID) },
+	"code":    func(meta *VideoMeta) string { return meta.Code },
+	"videoid": func(meta *VideoMeta) string { return meta.VideoID },
+	"site":    func(meta *VideoMeta) string { return siteName(meta.URL) },
+	"studio":  func(meta *VideoMeta) string { return meta.Studio },
+	"actor": func(meta *VideoMeta) string {
+		if len(meta.Actors) == 0 {
+			return ""
+		}
+		return meta.Actors[0]
+	},
+	"actors": func(meta *VideoMeta) string { return strings.Join(meta.Actors, ", ") },
+	"date":   func(meta *VideoMeta) string { return meta.ReleaseDate },
+	"year": func(meta *VideoMeta) string {
+		if len(meta.ReleaseDate) >= 4 {
+			return meta.ReleaseDate[:4]
+		}
+		return ""
+	},
+	"ext": func(*VideoMeta) string { return "mp4" },
+}
+
+var outputFieldRegex = regexp.MustCompile(`\{(\w+)\}`)
+
+// globalOutputNamer 输出文件命名，默认与之前相同（标题.mp4），但会处理非法字符和过长的标题
+var globalOutputNamer = mustOutputNamer(DEFAULT_OUTPUT_TEMPLATE, "")
+
+// OutputNamer 按模板生成输出路径，例如 "{site}/{id} - {title}.{ext}"，模板中的 / 表示子目录，
+// 字段值中的 / 会被替换。路径已被同一次运行中的其他视频使用，或磁盘上已有其他视频的文件时追加 " (2)"
+type OutputNamer struct {
+	template string
+	mode     string
+
+	mu      sync.Mutex
+	used    map[string]string // 小写路径 -> 视频地址，兼容不区分大小写的文件系统
+	archive *DownloadArchive  // 用于识别磁盘上已有文件属于哪个视频，可以为 nil
+}
+
+// NewOutputNamer mode 为 portable、windows、posix，为空时 Windows 上使用 windows，其他系统使用 portable
+func NewOutputNamer(template, mode string) (*OutputNamer, error) {
+	if template == "" {
+		template = DEFAULT_OUTPUT_TEMPLATE
+	}
+	for _, m := range outputFieldRegex.FindAllStringSubmatch(template, -1) {
+		if _, ok := outputFields[m[1]]; !ok {
+			return nil, fmt.Errorf("unknown output template field {%s}", m[1])
+		}
+	}
+	if !hasVideoField(template) {
+		return nil, fmt.Errorf("output template %q must contain {title}, {id}, {code} or {videoid}", template)
+	}
+	if mode == "" {
+		mode = filenameModePortable
+		if runtime.GOOS == "windows" {
+			mode = filenameModeWindows
+		}
+	}
+	switch mode {
+	case filenameModePortable, filenameModeWindows, filenameModePosix:
+	default:
+		return nil, fmt.Errorf("unknown filename mode %q", mode)
+	}
+	return &OutputNamer{template: template, mode: mode, used: make(map[string]string)}, nil
+}
+
+// hasVideoField 模板中包含每个视频都不同的字段
+func hasVideoField(template string) bool {
+	for _, field := range []string{"{title}", "{id}", "{code}", "{videoid}"} {
+		if strings.Contains(template, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// PerVideoDir 文件所在的目录名包含视频字段，即每个视频有单独的目录，例如 "{id}/{title}.{ext}"。
+// 此时冲突的序号追加在目录名上，目录中固定名称的文件（movie.nfo 等）不会被其他视频覆盖
+func (n *OutputNamer) PerVideoDir() bool {
+	dir := path.Dir(n.template)
+	return dir != "." && hasVideoField(path.Base(dir))
+}
+
+func mustOutputNamer(template, mode string) *OutputNamer {
+	n, err := NewOutputNamer(template, mode)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Render 返回不带扩展名的相对路径，不检查冲突
+func (n *OutputNamer) Render(meta *VideoMeta) string {
+	rendered := outputFieldRegex.ReplaceAllStringFunc(n.template, func(m string) string {
+		v := outputFields[m[1:len(m)-1]](meta)
+		// 字段值中的路径分隔符不能产生子目录
+		return strings.NewReplacer("/", "_", `\`, "_").Replace(v)
+	})
+	rendered = strings.TrimSuffix(rendered, ".mp4")
+
+	parts := strings.Split(rendered, "/")
+	for i, part := range parts {
+		part = truncateUTF8(n.sanitize(part), MAX_NAME_BYTES)
+		// 截断后可能出现新的结尾空格或点
+		part = n.sanitize(part)
+		if part == "" {
+			part = "_"
+		}
+		parts[i] = part
+	}
+	return filepath.Join(parts...)
+}
+
+// SetArchive 使用下载记录识别磁盘上已有的文件
+func (n *OutputNamer) SetArchive(da *DownloadArchive) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.archive = da
+}
+
+// Name 返回不带扩展名的相对路径（相对 outputDir），同一次运行中其他视频已使用的路径，
+// 或 outputDir 中已有其他视频的文件时追加序号。已有的文件属于同一个视频时返回该路径，下载时会跳过
+func (n *OutputNamer) Name(meta *VideoMeta, outputDir string) string {
+	base := n.Render(meta)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	name := base
+	for i := 2; ; i++ {
+		key := strings.ToLower(name)
+		owner, ok := n.used[key]
+		if ok && owner == meta.URL {
+			return name
+		}
+		if !ok {
+			file := filepath.Join(outputDir, name+".mp4")
+			if _, err := os.Stat(file); err != nil || n.sameVideoFile(file, meta) {
+				n.used[key] = meta.URL
+				return name
+			}
+		}
+		suffix := " (" + strconv.Itoa(i) + ")"
+		if n.PerVideoDir() {
+			name = filepath.Join(filepath.Dir(base)+suffix, filepath.Base(base))
+		} else {
+			name = base + suffix
+		}
+	}
+}
+
+// sameVideoFile 判断已存在的 file 是否为 meta 对应的视频，依次查看下载记录、NFO 和 MP4 中的来源地址，
+// 无法确定时视为其他视频，避免覆盖或误跳过
+func (n *OutputNamer) sameVideoFile(file string, meta *VideoMeta) bool {
+	siteOf := siteName
+	if n.archive != nil {
+		if same, found := n.archive.SameVideoAt(file, meta); found {
+			return same
+		}
+		siteOf = n.archive.siteOf
+	}
+	sameURL := func(rawURL string) bool {
+		return rawURL != "" && canonicalVideoURL(rawURL, siteOf) == canonicalVideoURL(meta.URL, siteOf)
+	}
+	nfoFiles := []string{strings.TrimSuffix(file, ".mp4") + ".nfo"}
+	if n.PerVideoDir() {
+		nfoFiles = append(nfoFiles, filepath.Join(filepath.Dir(file), "movie.nfo"))
+	}
+	for _, nfo := range nfoFiles {
+		website, code, err := readNFOIdentity(nfo)
+		if err == nil && (website != "" || code != "") {
+			return sameURL(website) || code != "" && strings.EqualFold(code, meta.Code)
+		}
+	}
+	comment, err := readMP4Comment(file)
+	return err == nil && sameURL(comment)
+}
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitize 处理单个文件/目录名
+func (n *OutputNamer) sanitize(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r == 0 || r == '/':
+			sb.WriteRune('_')
+		case n.mode == filenameModePosix:
+			sb.WriteRune(r)
+		case strings.ContainsRune(`<>:"\|?*`, r):
+			sb.WriteRune(fullWidthRune(r))
+		case unicode.IsControl(r) || r == utf8.RuneError:
+			continue
+		case n.mode == filenameModePortable && isEmoji(r):
+			continue
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	name = strings.Join(strings.Fields(sb.String()), " ")
+	if n.mode == filenameModePosix {
+		if name == "." || name == ".." {
+			return "_"
+		}
+		return name
+	}
+	name = strings.TrimRight(name, ". ")
+	stem := name
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(stem)] {
+		name = "_" + name
+	}
+	return name
+}
+
+// fullWidthRune Windows 不允许的字符替换为外观相近的全角字符，保留标题原意
+func fullWidthRune(r rune) rune {
+	switch r {
+	case '<':
+		return '＜'
+	case '>':
+		return '＞'
+	case ':':
+		return '：'
+	case '"':
+		return '＂'
+	case '\\':
+		return '＼'
+	case '|':
+		return '｜'
+	case '?':
+		return '？'
+	case '*':
+		return '＊'
+	}
+	return '_'
+}
+
+func isEmoji(r rune) bool {
+	return r >= 0x1F000 && r <= 0x1FAFF || r >= 0x2600 && r <= 0x27BF || r == 0xFE0F || r == 0x200D
+}
+
+// truncateUTF8 按字节截断，不会截断在多字节字符中间
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
+// siteName 视频页面的域名，去掉 www.
+func siteName(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
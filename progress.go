@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const (
+	// SPEED_WINDOW 下载速度按最近这段时间内的字节数计算
+	SPEED_WINDOW = 5 * time.Second
+	// PROGRESS_LOG_INTERVAL 日志方式输出进度的间隔
+	PROGRESS_LOG_INTERVAL = 10 * time.Second
+	// TTY_REDRAW_INTERVAL 终端进度条的最小刷新间隔
+	TTY_REDRAW_INTERVAL = 150 * time.Millisecond
+)
+
+const (
+	progressModeAuto = "auto" // 终端中使用 tty，否则使用 log
+	progressModeTTY  = "tty"
+	progressModeLog  = "log"
+	progressModeJSON = "json"
+	progressModeNone = "none"
+)
+
+// ProgressEventType 进度事件类型
+type ProgressEventType string
+
+const (
+	ProgressStart  ProgressEventType = "start"  // 任务总数已知，开始下载
+	ProgressTask   ProgressEventType = "task"   // 一个任务（分片）下载完成
+	ProgressRetry  ProgressEventType = "retry"  // 任务失败，重新排队
+	ProgressFail   ProgressEventType = "fail"   // 任务重试后仍然失败，放弃
+	ProgressFinish ProgressEventType = "finish" // 所有任务结束，开始合并
+)
+
+// ProgressEvent ConcurrencyRun 的进度，Completed、Bytes 等计数为本次运行的累计值，Resumed* 为之前运行已下载的部分
+type ProgressEvent struct {
+	Type      ProgressEventType `json:"type"`
+	Key       string            `json:"key"`  // 同时下载多个视频时用于区分，与 BudgetKey 相同
+	Name      string            `json:"name"` // 视频标题
+	Time      time.Time         `json:"time"`
+	Total     int               `json:"total"`     // 本次需要下载的任务数
+	Completed int               `json:"completed"` // 已完成的任务数
+	Failed    int               `json:"failed"`    // 最终失败的任务数
+	Retries   int               `json:"retries"`   // 累计重试次数
+	TaskBytes int64             `json:"task_bytes,omitempty"`
+	Bytes     int64             `json:"bytes"`     // 本次下载的字节数
+	Speed     float64           `json:"speed"`     // 最近 SPEED_WINDOW 内的速度（B/s）
+	AvgSpeed  float64           `json:"avg_speed"` // 本次运行的平均速度（B/s）
+	ETA       time.Duration     `json:"-"`         // 预计剩余时间，未知时为 0
+	Elapsed   time.Duration     `json:"-"`
+	Error     string            `json:"error,omitempty"` // retry/fail 的原因
+
+	Resumed       int     `json:"resumed"`        // 之前已下载、本次跳过的任务数
+	ResumedBytes  int64   `json:"resumed_bytes"`  // 之前已下载的字节数
+	ExpectedBytes int64   `json:"expected_bytes"` // 估算的总大小（含之前已下载的部分），未知时为 0
+	MediaSeconds  float64 `json:"media_seconds"`  // 已下载的视频时长（含之前已下载的部分）
+	TotalSeconds  float64 `json:"total_seconds"`  // playlist 的总时长，没有 #EXTINF 时为 0
+}
+
+// ProgressBaseline 断点续传时之前已下载的部分，以及 playlist 的总时长
+type ProgressBaseline struct {
+	Tasks        int
+	Bytes        int64
+	Seconds      float64
+	TotalSeconds float64
+}
+
+// DownloadedBytes 含之前已下载的部分
+func (ev *ProgressEvent) DownloadedBytes() int64 {
+	return ev.ResumedBytes + ev.Bytes
+}
+
+// Percent 有估算的总大小时按字节计算，否则按任务数计算，0~1
+func (ev *ProgressEvent) Percent() float64 {
+	if ev.Type == ProgressFinish && ev.Failed == 0 {
+		return 1
+	}
+	if ev.ExpectedBytes > 0 {
+		return min(float64(ev.DownloadedBytes())/float64(ev.ExpectedBytes), 1)
+	}
+	if total := ev.Resumed + ev.Total; total > 0 {
+		return float64(ev.Resumed+ev.Completed) / float64(total)
+	}
+	return 0
+}
+
+// MarshalJSON 时间以秒输出，方便其他程序使用
+func (ev ProgressEvent) MarshalJSON() ([]byte, error) {
+	type event ProgressEvent
+	return json.Marshal(struct {
+		event
+		ETA     float64 `json:"eta_seconds"`
+		Elapsed float64 `json:"elapsed_seconds"`
+	}{event(ev), ev.ETA.Seconds(), ev.Elapsed.Seconds()})
+}
+
+// ProgressReporter 接收 ConcurrencyRun 的进度，会被多个 worker 并发调用
+type ProgressReporter interface {
+	Report(ev ProgressEvent)
+}
+
+// ProgressFunc 作为库使用时通过回调接收进度
+type ProgressFunc func(ev ProgressEvent)
+
+func (f ProgressFunc) Report(ev ProgressEvent) {
+	f(ev)
+}
+
+// globalProgressReporter 为 nil 时不输出进度
+var globalProgressReporter ProgressReporter = NewLogReporter()
+
+// NewProgressReporter mode 为 auto、tty、log、json、none。json 输出到 stdout，tty 输出到 stderr
+func NewProgressReporter(mode string, stdout, stderr *os.File) (ProgressReporter, error) {
+	switch mode {
+	case "", progressModeAuto:
+		if term.IsTerminal(int(stderr.Fd())) {
+			return NewTTYReporter(stderr), nil
+		}
+		return NewLogReporter(), nil
+	case progressModeTTY:
+		return NewTTYReporter(stderr), nil
+	case progressModeLog:
+		return NewLogReporter(), nil
+	case progressModeJSON:
+		return NewJSONReporter(stdout), nil
+	case progressModeNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q", mode)
+}
+
+// progressTracker 统计一次 ConcurrencyRun 的进度并生成事件
+type progressTracker struct {
+	reporter ProgressReporter
+
+	mu      sync.Mutex
+	ev      ProgressEvent
+	start   time.Time
+	samples []byteSample
+}
+
+type byteSample struct {
+	t     time.Time
+	bytes int64
+}
+
+func newProgressTracker(reporter ProgressReporter, key, name string) *progressTracker {
+	return &progressTracker{
+		reporter: reporter,
+		ev:       ProgressEvent{Key: key, Name: name},
+		start:    time.Now(),
+	}
+}
+
+func (pt *progressTracker) Start(total int, base ProgressBaseline) {
+	pt.emit(ProgressStart, func(ev *ProgressEvent) {
+		ev.Total = total
+		ev.Resumed, ev.ResumedBytes = base.Tasks, base.Bytes
+		ev.MediaSeconds, ev.TotalSeconds = base.Seconds, base.TotalSeconds
+	})
+}
+
+// TaskDone seconds 为任务的视频时长，未知时为 0
+func (pt *progressTracker) TaskDone(bytes int64, seconds float64) {
+	pt.emit(ProgressTask, func(ev *ProgressEvent) {
+		ev.Completed++
+		ev.TaskBytes = bytes
+		ev.Bytes += bytes
+		ev.MediaSeconds += seconds
+	})
+}
+
+func (pt *progressTracker) Retry(err error) {
+	pt.emit(ProgressRetry, func(ev *ProgressEvent) {
+		ev.Retries++
+		ev.Error = errorString(err)
+	})
+}
+
+func (pt *progressTracker) Fail(err error) {
+	pt.emit(ProgressFail, func(ev *ProgressEvent) {
+		ev.Failed++
+		ev.Error = errorString(err)
+	})
+}
+
+func (pt *progressTracker) Finish() {
+	pt.emit(ProgressFinish, func(*ProgressEvent) {})
+}
+
+func (pt *progressTracker) emit(typ ProgressEventType, update func(ev *ProgressEvent)) {
+	if pt.reporter == nil {
+		return
+	}
+	pt.mu.Lock()
+	now := time.Now()
+	pt.ev.Type, pt.ev.Time, pt.ev.TaskBytes, pt.ev.Error = typ, now, 0, ""
+	update(&pt.ev)
+	pt.ev.Elapsed = now.Sub(pt.start)
+	pt.updateSpeed(now)
+	if pt.ev.Elapsed > 0 {
+		pt.ev.AvgSpeed = float64(pt.ev.Bytes) / pt.ev.Elapsed.Seconds()
+	}
+	pt.estimate()
+	if typ == ProgressFinish {
+		pt.ev.ETA = 0
+		if pt.ev.Failed == 0 {
+			pt.ev.ExpectedBytes = pt.ev.DownloadedBytes()
+		}
+	}
+	ev := pt.ev
+	pt.mu.Unlock()
+	pt.reporter.Report(ev)
+}
+
+// estimate 估算总大小和剩余时间。码率来自已下载分片（含之前下载的）的实际大小（Content-Length）和 #EXTINF 时长，
+// 乘以 playlist 总时长得到总大小，开头几个分片下载完成后就有估算值，之后随下载不断修正。
+// 没有时长信息时按平均分片大小估算
+func (pt *progressTracker) estimate() {
+	ev := &pt.ev
+	downloaded := ev.DownloadedBytes()
+	switch {
+	case ev.TotalSeconds > 0 && ev.MediaSeconds > 0:
+		ev.ExpectedBytes = int64(float64(downloaded) / ev.MediaSeconds * ev.TotalSeconds)
+	case ev.Completed > 0:
+		perTask := float64(downloaded) / float64(ev.Resumed+ev.Completed)
+		ev.ExpectedBytes = int64(perTask * float64(ev.Resumed+ev.Total))
+	}
+	ev.ExpectedBytes = max(ev.ExpectedBytes, downloaded)
+
+	speed := ev.Speed
+	if speed <= 0 {
+		speed = ev.AvgSpeed
+	}
+	ev.ETA = 0
+	if ev.ExpectedBytes > 0 && speed > 0 {
+		ev.ETA = time.Duration(float64(ev.ExpectedBytes-downloaded) / speed * float64(time.Second))
+	} else if done := ev.Completed + ev.Failed; done > 0 && done < ev.Total {
+		ev.ETA = time.Duration(float64(ev.Elapsed) / float64(done) * float64(ev.Total-done))
+	}
+}
+
+// updateSpeed 保留 SPEED_WINDOW 内的采样，用最早的采样计算速度
+func (pt *progressTracker) updateSpeed(now time.Time) {
+	pt.samples = append(pt.samples, byteSample{now, pt.ev.Bytes})
+	i := 0
+	for i < len(pt.samples)-1 && now.Sub(pt.samples[i].t) > SPEED_WINDOW {
+		i++
+	}
+	pt.samples = pt.samples[i:]
+	first := pt.samples[0]
+	if dt := now.Sub(first.t); dt > 0 {
+		pt.ev.Speed = float64(pt.ev.Bytes-first.bytes) / dt.Seconds()
+	} else if pt.ev.Elapsed > 0 {
+		pt.ev.Speed = float64(pt.ev.Bytes) / pt.ev.Elapsed.Seconds()
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// LogReporter 每隔 PROGRESS_LOG_INTERVAL 输出一行日志，适合重定向到文件
+type LogReporter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func NewLogReporter() *LogReporter {
+	return &LogReporter{last: make(map[string]time.Time)}
+}
+
+func (r *LogReporter) Report(ev ProgressEvent) {
+	switch ev.Type {
+	case ProgressStart:
+		slog.Info("Download started", "video_id", ev.Key, "title", ev.Name, "segments", ev.Total,
+			"resumed", ev.Resumed, "resumed_bytes", ev.ResumedBytes)
+	case ProgressFail:
+		slog.Error("Segment failed after retries", "video_id", ev.Key, "title", ev.Name, "error", ev.Error)
+	case ProgressFinish:
+		r.mu.Lock()
+		delete(r.last, ev.Key)
+		r.mu.Unlock()
+		slog.Info("Segments downloaded", "video_id", ev.Key, "title", ev.Name, "completed", ev.Completed, "segments", ev.Total,
+			"failed", ev.Failed, "bytes", ev.Bytes, "elapsed", ev.Elapsed.Round(time.Second), "avg_speed", formatBytes(int64(ev.AvgSpeed))+"/s")
+	case ProgressTask:
+		r.mu.Lock()
+		due := time.Since(r.last[ev.Key]) >= PROGRESS_LOG_INTERVAL
+		if due {
+			r.last[ev.Key] = time.Now()
+		}
+		r.mu.Unlock()
+		if due {
+			slog.Info("Progress", "video_id", ev.Key, "title", ev.Name, "completed", ev.Completed, "segments", ev.Total,
+				"bytes", ev.DownloadedBytes(), "expected_bytes", ev.ExpectedBytes, "speed", formatBytes(int64(ev.Speed))+"/s",
+				"eta", ev.ETA.Round(time.Second), "retries", ev.Retries, "failed", ev.Failed)
+		}
+	}
+}
+
+// JSONReporter 每个事件输出一行 JSON
+type JSONReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(w)}
+}
+
+func (r *JSONReporter) Report(ev ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_ = r.enc.Encode(ev)
+}
+
+// TTYReporter 在终端底部为每个正在下载的视频显示一行进度条。
+// 作为 log 的输出时，日志会输出在进度条上方，不会与进度条交错
+type TTYReporter struct {
+	mu       sync.Mutex
+	out      *os.File
+	bars     map[string]ProgressEvent
+	order    []string
+	drawn    int // 当前屏幕上的进度条行数
+	lastDraw time.Time
+}
+
+func NewTTYReporter(out *os.File) *TTYReporter {
+	return &TTYReporter{out: out, bars: make(map[string]ProgressEvent)}
+}
+
+func (r *TTYReporter) Report(ev ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bars[ev.Key]; !ok {
+		r.order = append(r.order, ev.Key)
+	}
+	r.bars[ev.Key] = ev
+	if ev.Type == ProgressFinish {
+		// 结束的进度条固定在日志区域，不再刷新
+		r.clear()
+		fmt.Fprintf(r.out, "%s\n", formatProgressLine(ev, r.width()))
+		delete(r.bars, ev.Key)
+		for i, key := range r.order {
+			if key == ev.Key {
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				break
+			}
+		}
+		r.draw()
+		return
+	}
+	if ev.Type == ProgressStart || time.Since(r.lastDraw) >= TTY_REDRAW_INTERVAL {
+		r.clear()
+		r.draw()
+	}
+}
+
+// Write 实现 io.Writer，用作 log 的输出
+func (r *TTYReporter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clear()
+	n, err := r.out.Write(p)
+	r.draw()
+	return n, err
+}
+
+func (r *TTYReporter) clear() {
+	for ; r.drawn > 0; r.drawn-- {
+		fmt.Fprint(r.out, "\x1b[1A\x1b[2K")
+	}
+}
+
+func (r *TTYReporter) draw() {
+	width := r.width()
+	for _, key := range r.order {
+		fmt.Fprintf(r.out, "%s\n", formatProgressLine(r.bars[key], width))
+		r.drawn++
+	}
+	r.lastDraw = time.Now()
+}
+
+func (r *TTYReporter) width() int {
+	if w, _, err := term.GetSize(int(r.out.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// formatProgressLine "标题 [=====>    ]  45% 123/456 12.3 MB 3.2 MB/s ETA 1m20s"，width 为 0 时不显示进度条
+func formatProgressLine(ev ProgressEvent, width int) string {
+	percent := ev.Percent()
+	size := formatBytes(ev.DownloadedBytes())
+	if ev.ExpectedBytes > 0 && ev.Type != ProgressFinish {
+		size += "/~" + formatBytes(ev.ExpectedBytes)
+	}
+	stats := fmt.Sprintf("%3.0f%% %s %s/s", percent*100, size, formatBytes(int64(ev.Speed)))
+	if ev.TotalSeconds > 0 {
+		stats += " " + formatClock(ev.MediaSeconds) + "/" + formatClock(ev.TotalSeconds)
+	}
+	if ev.Type == ProgressFinish {
+		stats = fmt.Sprintf("%3.0f%% %s in %s (avg %s/s)", percent*100, size, ev.Elapsed.Round(time.Second), formatBytes(int64(ev.AvgSpeed)))
+	} else if ev.ETA > 0 {
+		stats += " ETA " + ev.ETA.Round(time.Second).String()
+	}
+	if ev.Retries > 0 || ev.Failed > 0 {
+		stats += fmt.Sprintf(" retries %d failed %d", ev.Retries, ev.Failed)
+	}
+	if width == 0 {
+		return ev.Name + ": " + stats
+	}
+	name := truncateWidth(ev.Name, 30)
+	barWidth := width - len(stats) - 30 - 5
+	if barWidth < 10 {
+		return truncateWidth(name+" "+stats, width-1)
+	}
+	filled := int(percent * float64(barWidth))
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("%s [%s] %s", name, bar, stats)
+}
+
+// truncateWidth 按字符截断，中日文按两个字符宽度计算，不足时补空格
+func truncateWidth(s string, width int) string {
+	var sb strings.Builder
+	w := 0
+	for _, r := range s {
+		rw := 1
+		if utf8.RuneLen(r) > 2 {
+			rw = 2
+		}
+		if w+rw > width {
+			break
+		}
+		sb.WriteRune(r)
+		w += rw
+	}
+	return sb.String() + strings.Repeat(" ", max(width-w, 0))
+}
+
+// formatClock 3725.4 -> "1:02:05"
+func formatClock(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// formatBytes 1536 -> "1.5 KB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/chromedp"
+)
+
+// globalProxyConfig 全局代理配置，浏览器和下载请求共用
+var globalProxyConfig = &ProxyConfig{}
+
+const (
+	proxyDirect = "direct" // 不使用代理
+	proxyEnv    = "env"    // 使用 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量
+)
+
+type proxyScope int
+
+const (
+	proxyScopeMeta  proxyScope = iota // 页面/元数据请求（chromedp、HTTP 提取器）
+	proxyScopeMedia                   // m3u8、key、ts 请求
+)
+
+// ProxyConfig 代理配置，代理地址支持 http://、https://、socks5://，可带 user:pass@ 认证。
+// 空值或 "env" 表示使用环境变量，"direct" 表示直连
+type ProxyConfig struct {
+	Default string      `json:"default"`
+	Rules   []ProxyRule `json:"rules"`
+}
+
+// ProxyRule 按 host 指定代理，host 同时匹配视频页面所在站点和实际请求的 host，
+// 例如 {"host": "*.jable.tv", "meta": "socks5://127.0.0.1:1080", "media": "direct"}
+type ProxyRule struct {
+	Host  string `json:"host"`
+	Meta  string `json:"meta"`
+	Media string `json:"media"`
+}
+
+func (r ProxyRule) match(u *url.URL) bool {
+	return u != nil && matchHostPattern(r.Host, u.Hostname())
+}
+
+// Resolve 返回请求应使用的代理，nil 表示直连。site 为视频页面地址，target 为实际请求地址
+func (pc *ProxyConfig) Resolve(scope proxyScope, site, target *url.URL) (*url.URL, error) {
+	for _, r := range pc.Rules {
+		if !r.match(site) && !r.match(target) {
+			continue
+		}
+		v := r.Media
+		if scope == proxyScopeMeta {
+			v = r.Meta
+		}
+		if v != "" {
+			return parseProxyValue(v, target)
+		}
+	}
+	return parseProxyValue(pc.Default, target)
+}
+
+func parseProxyValue(v string, target *url.URL) (*url.URL, error) {
+	switch strings.ToLower(v) {
+	case "", proxyEnv:
+		return http.ProxyFromEnvironment(&http.Request{URL: target})
+	case proxyDirect:
+		return nil, nil
+	}
+	return url.Parse(v)
+}
+
+type proxySiteKey struct{}
+
+// withProxySite 在请求上下文中记录视频页面地址，供按站点匹配代理规则
+func withProxySite(ctx context.Context, site string) context.Context {
+	if site == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, proxySiteKey{}, site)
+}
+
+type proxyScopeKey struct{}
+
+// withProxyScope 在请求上下文中记录代理作用域，未设置时按 proxyScopeMedia 处理
+func withProxyScope(ctx context.Context, scope proxyScope) context.Context {
+	return context.WithValue(ctx, proxyScopeKey{}, scope)
+}
+
+// proxyForRequest 用作 http.Transport.Proxy
+func proxyForRequest(req *http.Request) (*url.URL, error) {
+	var site *url.URL
+	if s, ok := req.Context().Value(proxySiteKey{}).(string); ok {
+		site, _ = url.Parse(s)
+	}
+	scope := proxyScopeMedia
+	if s, ok := req.Context().Value(proxyScopeKey{}).(proxyScope); ok {
+		scope = s
+	}
+	return globalProxyConfig.Resolve(scope, site, req.URL)
+}
+
+// browserProxyOptions 生成 chromedp 的代理启动参数，Chrome 不支持在 --proxy-server 中携带认证信息，
+// 认证由 enableProxyAuth 处理
+func browserProxyOptions(proxyURL *url.URL) []chromedp.ExecAllocatorOption {
+	if proxyURL == nil {
+		return nil
+	}
+	opts := []chromedp.ExecAllocatorOption{
+		chromedp.ProxyServer(proxyURL.Scheme + "://" + proxyURL.Host),
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	if noProxy != "" {
+		opts = append(opts, chromedp.Flag("proxy-bypass-list", strings.ReplaceAll(noProxy, ",", ";")))
+	}
+	if proxyURL.User != nil && strings.HasPrefix(proxyURL.Scheme, "socks") {
+		slog.Warn("Chrome does not support SOCKS proxy authentication, credentials are ignored", "proxy", proxyURL.Host)
+	}
+	return opts
+}
+
+// enableProxyAuth 拦截代理认证请求并使用代理地址中的用户名密码应答
+func enableProxyAuth(ctx context.Context, proxyURL *url.URL) error {
+	if proxyURL == nil || proxyURL.User == nil || strings.HasPrefix(proxyURL.Scheme, "socks") {
+		return nil
+	}
+	username := proxyURL.User.Username()
+	password, _ := proxyURL.User.Password()
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				_ = chromedp.Run(ctx, fetch.ContinueRequest(ev.RequestID))
+			}()
+		case *fetch.EventAuthRequired:
+			go func() {
+				_ = chromedp.Run(ctx, fetch.ContinueWithAuth(ev.RequestID, &fetch.AuthChallengeResponse{
+					Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+					Username: username,
+					Password: password,
+				}))
+			}()
+		}
+	})
+	return chromedp.Run(ctx, fetch.Enable().WithHandleAuthRequests(true))
+}
+
+// matchHostPattern 支持精确匹配、"*" 和 "*.example.com"（同时匹配 example.com 本身）
+func matchHostPattern(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if pattern == "*" || pattern == host {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return false
+}
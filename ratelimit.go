@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 全局限速器：所有视频、所有 worker 共享同一个带宽上限、每个 host 的连接数上限和分片 worker 总数
+var (
+	globalRateLimiter  = NewRateLimiter(0)
+	globalHostLimiter  = NewHostLimiter(0)
+	globalWorkerBudget = NewWorkerBudget(DOWNLOAD_WORKERS)
+)
+
+const (
+	// 单次读取的最大字节数，避免一次 Read 消耗过多令牌导致长时间阻塞
+	rateReadChunk = 32 * 1024
+	// 等待令牌时的最长休眠时间，保证运行时调整速率能尽快生效
+	rateMaxWait = 100 * time.Millisecond
+)
+
+// RateLimiter 令牌桶限速器，单位 bytes/s，rate <= 0 表示不限速
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	rl := &RateLimiter{}
+	rl.SetRate(bytesPerSec)
+	return rl
+}
+
+// SetRate 运行时修改速率，已积累的令牌不会超过新的桶容量
+func (rl *RateLimiter) SetRate(bytesPerSec int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rate = bytesPerSec
+	rl.last = time.Now()
+	if burst := float64(rl.burst()); rl.tokens > burst {
+		rl.tokens = burst
+	}
+}
+
+func (rl *RateLimiter) Rate() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// burst 桶容量为 1 秒的流量，但至少能容纳一次读取
+func (rl *RateLimiter) burst() int64 {
+	if rl.rate < rateReadChunk {
+		return rateReadChunk
+	}
+	return rl.rate
+}
+
+// WaitN 阻塞直到获得 n 个字节的令牌
+func (rl *RateLimiter) WaitN(n int) {
+	for {
+		rl.mu.Lock()
+		if rl.rate <= 0 {
+			rl.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		rl.tokens += now.Sub(rl.last).Seconds() * float64(rl.rate)
+		rl.last = now
+		if burst := float64(rl.burst()); rl.tokens > burst {
+			rl.tokens = burst
+		}
+		if rl.tokens >= float64(n) {
+			rl.tokens -= float64(n)
+			rl.mu.Unlock()
+			return
+		}
+		wait := time.Duration((float64(n) - rl.tokens) / float64(rl.rate) * float64(time.Second))
+		rl.mu.Unlock()
+
+		if wait > rateMaxWait {
+			wait = rateMaxWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Reader 返回一个受限速器控制的 io.Reader
+func (rl *RateLimiter) Reader(r io.Reader) io.Reader {
+	return &rateLimitedReader{r: r, rl: rl}
+}
+
+type rateLimitedReader struct {
+	r  io.Reader
+	rl *RateLimiter
+}
+
+// idlePauser 带空闲超时的 reader，等待令牌的时间不计入空闲时间
+type idlePauser interface {
+	pauseIdle()
+	resumeIdle()
+}
+
+func (lr *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateReadChunk {
+		p = p[:rateReadChunk]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if ip, ok := lr.r.(idlePauser); ok {
+			// 低速率、多 worker 时一次等待可能超过 BODY_IDLE_TIMEOUT
+			ip.pauseIdle()
+			defer ip.resumeIdle()
+		}
+		lr.rl.WaitN(n)
+	}
+	return n, err
+}
+
+// HostLimiter 限制每个 host 的并发连接数，limit <= 0 表示不限制
+type HostLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active map[string]int
+}
+
+func NewHostLimiter(limit int) *HostLimiter {
+	hl := &HostLimiter{
+		limit:  limit,
+		active: make(map[string]int),
+	}
+	hl.cond = sync.NewCond(&hl.mu)
+	return hl
+}
+
+// SetLimit 运行时修改上限，调大时会唤醒等待中的 worker
+func (hl *HostLimiter) SetLimit(limit int) {
+	hl.mu.Lock()
+	hl.limit = limit
+	hl.mu.Unlock()
+	hl.cond.Broadcast()
+}
+
+func (hl *HostLimiter) Limit() int {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	return hl.limit
+}
+
+func (hl *HostLimiter) Acquire(host string) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	for hl.limit > 0 && hl.active[host] >= hl.limit {
+		hl.cond.Wait()
+	}
+	hl.active[host]++
+}
+
+func (hl *HostLimiter) Release(host string) {
+	hl.mu.Lock()
+	hl.active[host]--
+	if hl.active[host] <= 0 {
+		delete(hl.active, host)
+	}
+	hl.mu.Unlock()
+	hl.cond.Broadcast()
+}
+
+// WorkerBudget 多个视频同时下载时共享的分片 worker 名额，名额释放后按视频轮流分配，
+// 避免分片很多的大视频占满所有名额
+type WorkerBudget struct {
+	mu       sync.Mutex
+	capacity int
+	inUse    int
+	waiters  map[string][]chan struct{}
+	order    []string // 有等待者的视频，按轮询顺序
+}
+
+func NewWorkerBudget(capacity int) *WorkerBudget {
+	return &WorkerBudget{capacity: capacity, waiters: make(map[string][]chan struct{})}
+}
+
+// SetCapacity 运行时修改名额总数，调大时立即分配给等待中的 worker
+func (wb *WorkerBudget) SetCapacity(capacity int) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.capacity = capacity
+	for wb.inUse < wb.capacity && wb.handOffLocked() {
+		wb.inUse++
+	}
+}
+
+func (wb *WorkerBudget) Capacity() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.capacity
+}
+
+// Acquire owner 为视频标识，同一视频的 worker 排在同一个队列中
+func (wb *WorkerBudget) Acquire(owner string) {
+	wb.mu.Lock()
+	if wb.capacity <= 0 || wb.inUse < wb.capacity && len(wb.order) == 0 {
+		wb.inUse++
+		wb.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	if len(wb.waiters[owner]) == 0 {
+		wb.order = append(wb.order, owner)
+	}
+	wb.waiters[owner] = append(wb.waiters[owner], ch)
+	wb.mu.Unlock()
+	<-ch
+}
+
+// Release 名额直接转交给下一个视频的等待者，没有等待者时归还
+func (wb *WorkerBudget) Release() {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.inUse <= wb.capacity && wb.handOffLocked() {
+		return
+	}
+	wb.inUse--
+}
+
+// handOffLocked 唤醒轮询顺序中下一个视频的第一个等待者
+func (wb *WorkerBudget) handOffLocked() bool {
+	if len(wb.order) == 0 {
+		return false
+	}
+	owner := wb.order[0]
+	wb.order = wb.order[1:]
+	queue := wb.waiters[owner]
+	close(queue[0])
+	if len(queue) > 1 {
+		wb.waiters[owner] = queue[1:]
+		// 该视频还有等待者，排到队尾
+		wb.order = append(wb.order, owner)
+	} else {
+		delete(wb.waiters, owner)
+	}
+	return true
+}
+
+// parseByteRate 解析 "500K"、"2M"、"1.5MB"、"0" 这类速率字符串，返回 bytes/s
+func parseByteRate(rate string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(rate))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+	unit := float64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate: %q", rate)
+	}
+	return int64(v * unit), nil
+}
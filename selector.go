@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector 简化版 CSS 选择器，支持：
+//   - tag、*、#id、.class
+//   - [attr]、[attr=v]、[attr~=v]、[attr^=v]、[attr$=v]、[attr*=v]
+//   - :first-child、:nth-child(n)，n 可以是数字、odd、even 或 an+b
+//   - 后代（空格）和子元素（>）组合，多个选择器用逗号分隔
+type Selector []cssComplex
+
+type cssComplex struct {
+	parts       []cssCompound
+	combinators []byte // combinators[i] 连接 parts[i] 和 parts[i+1]，' ' 或 '>'
+}
+
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []cssAttr
+	nth     *cssNth
+}
+
+// cssNth :nth-child(an+b)，位置从 1 开始
+type cssNth struct {
+	a, b int
+}
+
+type cssAttr struct {
+	name  string
+	op    string
+	value string
+}
+
+func CompileSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, group := range splitSelectorGroups(s) {
+		c, err := compileComplex(strings.TrimSpace(group))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		sel = append(sel, c)
+	}
+	return sel, nil
+}
+
+// splitSelectorGroups 按逗号拆分选择器组，忽略属性选择器的方括号和引号内的逗号
+func splitSelectorGroups(s string) []string {
+	var groups []string
+	start, depth := 0, 0
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case quote != 0:
+			if b == '\\' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '[':
+			depth++
+		case b == ']':
+			if depth > 0 {
+				depth--
+			}
+		case b == ',' && depth == 0:
+			groups = append(groups, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(groups, s[start:])
+}
+
+// closingBracket 返回 s（以 '[' 开头）中对应 ']' 的位置，跳过引号内的内容
+func closingBracket(s string) int {
+	quote := byte(0)
+	for i := 1; i < len(s); i++ {
+		switch b := s[i]; {
+		case quote != 0:
+			if b == '\\' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func compileComplex(s string) (cssComplex, error) {
+	var c cssComplex
+	if s == "" {
+		return c, fmt.Errorf("empty selector")
+	}
+	pendingComb := byte(0)
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case ' ', '\t', '\n':
+			if pendingComb == 0 {
+				pendingComb = ' '
+			}
+			i++
+			continue
+		case '>':
+			pendingComb = '>'
+			i++
+			continue
+		}
+		compound, n, err := compileCompound(s[i:])
+		if err != nil {
+			return c, err
+		}
+		if len(c.parts) > 0 {
+			if pendingComb == 0 {
+				pendingComb = ' '
+			}
+			c.combinators = append(c.combinators, pendingComb)
+		} else if pendingComb == '>' {
+			return c, fmt.Errorf("selector starts with combinator")
+		}
+		pendingComb = 0
+		c.parts = append(c.parts, compound)
+		i += n
+	}
+	if pendingComb == '>' {
+		return c, fmt.Errorf("selector ends with combinator")
+	}
+	return c, nil
+}
+
+// compileCompound 解析一个复合选择器，返回消耗的字节数
+func compileCompound(s string) (cssCompound, int, error) {
+	var c cssCompound
+	i := 0
+	ident := func() string {
+		start := i
+		for i < len(s) && isSelectorIdentChar(s[i]) {
+			i++
+		}
+		return s[start:i]
+	}
+	if i < len(s) && s[i] == '*' {
+		i++
+	} else {
+		c.tag = strings.ToLower(ident())
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '#':
+			i++
+			c.id = ident()
+		case '.':
+			i++
+			c.classes = append(c.classes, ident())
+		case '[':
+			end := closingBracket(s[i:])
+			if end < 0 {
+				return c, i, fmt.Errorf("unclosed attribute selector")
+			}
+			c.attrs = append(c.attrs, parseCSSAttr(s[i+1:i+end]))
+			i += end + 1
+		case ':':
+			i++
+			switch name := strings.ToLower(ident()); name {
+			case "first-child":
+				c.nth = &cssNth{b: 1}
+			case "nth-child":
+				end := strings.IndexByte(s[i:], ')')
+				if i >= len(s) || s[i] != '(' || end < 0 {
+					return c, i, fmt.Errorf(":nth-child requires an argument")
+				}
+				nth, err := parseCSSNth(s[i+1 : i+end])
+				if err != nil {
+					return c, i, err
+				}
+				c.nth = &nth
+				i += end + 1
+			default:
+				return c, i, fmt.Errorf("unsupported pseudo-class :%s", name)
+			}
+		default:
+			if i == 0 {
+				return c, i, fmt.Errorf("unexpected %q", s[i])
+			}
+			return c, i, nil
+		}
+	}
+	return c, i, nil
+}
+
+func parseCSSAttr(s string) cssAttr {
+	// 属性名中不会出现 '='，第一个 '=' 及其前一个字符就是运算符，值中可以包含 '=' 等字符
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return cssAttr{name: strings.ToLower(strings.TrimSpace(s))}
+	}
+	nameEnd, op := eq, "="
+	if eq > 0 && strings.IndexByte("~^$*", s[eq-1]) >= 0 {
+		nameEnd, op = eq-1, s[eq-1:eq+1]
+	}
+	value := strings.Trim(strings.TrimSpace(s[eq+1:]), `"'`)
+	return cssAttr{name: strings.ToLower(strings.TrimSpace(s[:nameEnd])), op: op, value: value}
+}
+
+// parseCSSNth 解析 :nth-child 的参数：odd、even、b、an、an+b
+func parseCSSNth(s string) (cssNth, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	switch s {
+	case "odd":
+		return cssNth{a: 2, b: 1}, nil
+	case "even":
+		return cssNth{a: 2}, nil
+	}
+	var nth cssNth
+	var err error
+	aPart, bPart, hasN := strings.Cut(s, "n")
+	if !hasN {
+		nth.b, err = strconv.Atoi(s)
+	} else {
+		switch aPart {
+		case "", "+":
+			nth.a = 1
+		case "-":
+			nth.a = -1
+		default:
+			nth.a, err = strconv.Atoi(aPart)
+		}
+		if err == nil && bPart != "" {
+			nth.b, err = strconv.Atoi(bPart)
+		}
+	}
+	if err != nil {
+		return nth, fmt.Errorf("invalid :nth-child argument %q", s)
+	}
+	return nth, nil
+}
+
+// match pos 为元素在兄弟元素中的位置
+func (nth cssNth) match(pos int) bool {
+	if nth.a == 0 {
+		return pos == nth.b
+	}
+	k := pos - nth.b
+	return k%nth.a == 0 && k/nth.a >= 0
+}
+
+// childPosition 返回元素在兄弟元素中的位置，从 1 开始
+func childPosition(n *html.Node) int {
+	pos := 1
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			pos++
+		}
+	}
+	return pos
+}
+
+func isSelectorIdentChar(b byte) bool {
+	return b == '-' || b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// MatchAll 按文档顺序返回所有匹配的元素
+func (sel Selector) MatchAll(root *html.Node) []*html.Node {
+	var result []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, c := range sel {
+				if c.match(n, len(c.parts)-1) {
+					result = append(result, n)
+					break
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	return result
+}
+
+func (c cssComplex) match(n *html.Node, idx int) bool {
+	if !c.parts[idx].match(n) {
+		return false
+	}
+	if idx == 0 {
+		return true
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		if c.match(p, idx-1) {
+			return true
+		}
+		if c.combinators[idx-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+func (c cssCompound) match(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && nodeAttr(n, "id") != c.id {
+		return false
+	}
+	if c.nth != nil && !c.nth.match(childPosition(n)) {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(nodeAttr(n, "class"))
+		for _, want := range c.classes {
+			if !slices.Contains(classes, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		v, ok := lookupAttr(n, a.name)
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = v == a.value
+		case "~=":
+			ok = slices.Contains(strings.Fields(v), a.value)
+		case "^=":
+			ok = strings.HasPrefix(v, a.value)
+		case "$=":
+			ok = strings.HasSuffix(v, a.value)
+		case "*=":
+			ok = strings.Contains(v, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupAttr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func nodeAttr(n *html.Node, name string) string {
+	v, _ := lookupAttr(n, name)
+	return v
+}
+
+// nodeText 返回元素内所有文本节点拼接后的内容
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const selectorTestHTML = `<html><body>
+<div id="main" class="video card">
+  <h1 class="title">Title</h1>
+  <ul class="tags">
+    <li><a href="/tag/a" data-kind="tag genre">A</a></li>
+    <li><a href="/tag/b" data-kind="tag">B</a></li>
+    <li><span><a href="https://cdn.test/c.m3u8?x=1,2">C</a></span></li>
+  </ul>
+</div>
+<p class="title" data-x="a=b]">P</p>
+</body></html>`
+
+// selectorTestLabel 用元素文本标识匹配结果
+func selectorTestLabel(nodes []*html.Node) []string {
+	var labels []string
+	for _, n := range nodes {
+		labels = append(labels, nodeText(n))
+	}
+	return labels
+}
+
+func TestSelectorMatch(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(selectorTestHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"h1", []string{"Title"}},
+		{"#main .title", []string{"Title"}},
+		{".title", []string{"Title", "P"}},
+		{"div.video.card > h1", []string{"Title"}},
+		{"div.video.other h1", nil},
+		{"ul a", []string{"A", "B", "C"}},
+		{"ul > li > a", []string{"A", "B"}},
+		{"ul>li>a", []string{"A", "B"}},
+		{"body > h1", nil},
+		{"li *", []string{"A", "B", "C", "C"}},
+		{"a[data-kind]", []string{"A", "B"}},
+		{"a[data-kind=tag]", []string{"B"}},
+		{`a[data-kind="tag genre"]`, []string{"A"}},
+		{"a[data-kind~=genre]", []string{"A"}},
+		{"a[href^=https]", []string{"C"}},
+		{"a[href$='.m3u8?x=1,2']", []string{"C"}},
+		{"a[href*=tag]", []string{"A", "B"}},
+		{`p[data-x="a=b]"]`, []string{"P"}},
+		{"li:first-child a", []string{"A"}},
+		{"li:nth-child(2) > a", []string{"B"}},
+		{"li:nth-child(odd) a", []string{"A", "C"}},
+		{"li:nth-child(even) a", []string{"B"}},
+		{"li:nth-child(2n+1) a", []string{"A", "C"}},
+		{"li:nth-child(-n+2) > a", []string{"A", "B"}},
+		// 文档顺序返回，同一元素只返回一次
+		{"p, h1", []string{"Title", "P"}},
+		{"h1, .title", []string{"Title", "P"}},
+		{"a[href$='1,2'], h1", []string{"Title", "C"}},
+	}
+	for _, tt := range tests {
+		sel, err := CompileSelector(tt.selector)
+		if err != nil {
+			t.Errorf("CompileSelector(%q): %v", tt.selector, err)
+			continue
+		}
+		if got := selectorTestLabel(sel.MatchAll(doc)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestCompileSelectorErrors(t *testing.T) {
+	for _, s := range []string{"", "a,", "> a", "a >", "a[href", "li:hover", "li:nth-child", "li:nth-child(x)", "!"} {
+		if _, err := CompileSelector(s); err == nil {
+			t.Errorf("CompileSelector(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	extractPathHTTP    = "http"    // 纯 HTTP 提取，失败时回退到浏览器
+	extractPathBrowser = "browser" // 只使用浏览器
+)
+
+// 录制/回放时使用的钩子，正常运行时均为空
+var (
+	// forceBrowserExtractor 为 true 时 HTTPFirstFetchVideoMeta 跳过纯 HTTP 提取
+	forceBrowserExtractor bool
+	// onBrowserTab 每个浏览器标签页开启网络监听后调用，返回的函数在标签页关闭前调用
+	onBrowserTab func(ctx context.Context) func()
+)
+
+// Fixture 某个站点的录制结果，保存在 <fixtures>/<name>/fixture.json，响应内容保存在同目录下
+type Fixture struct {
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	Synthetic bool              `json:"synthetic,omitempty"` // 按 handler 手写的示例页面，不是 record 录制的真实响应
+	Paths     []string          `json:"paths"`
+	Expect    FixtureExpect     `json:"expect"`
+	Responses []FixtureResponse `json:"responses"`
+
+	dir string
+}
+
+type FixtureExpect struct {
+	Title   string `json:"title"`
+	M3u8URL string `json:"m3u8_url"`
+	Error   string `json:"error,omitempty"` // 不为空时期望抓取失败且错误信息包含该内容，例如不支持的站点
+}
+
+type FixtureResponse struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	File        string `json:"file"`
+}
+
+func loadFixture(dir string) (*Fixture, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "fixture.json"))
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixture{dir: dir}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("parse fixture %s: %w", dir, err)
+	}
+	return f, nil
+}
+
+// loadFixtures 读取 fixturesDir 下的所有录制结果，按目录名排序
+func loadFixtures(fixturesDir string) ([]*Fixture, []error) {
+	files, _ := filepath.Glob(filepath.Join(fixturesDir, "*", "fixture.json"))
+	sort.Strings(files)
+	var fixtures []*Fixture
+	var errs []error
+	for _, file := range files {
+		fixture, err := loadFixture(filepath.Dir(file))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, errs
+}
+
+// untestedSites 返回没有录制结果的已注册站点，按注册顺序
+func (dm *DLMaster) untestedSites(fixtures []*Fixture) []string {
+	tested := make(map[string]bool)
+	for _, fixture := range fixtures {
+		if m, ok := dm.sites.Match(fixture.URL); ok && len(fixture.Paths) > 0 {
+			tested[m.Name] = true
+		}
+	}
+	var missing []string
+	for _, m := range dm.sites.matchers {
+		if !tested[m.name] {
+			tested[m.name] = true
+			missing = append(missing, m.name)
+		}
+	}
+	return missing
+}
+
+// RunSelfTest 回放 fixturesDir 下的所有录制结果，校验每个站点提取到的标题和 m3u8 地址，
+// names 不为空时只测试指定站点，否则没有录制结果的已注册站点也算失败。返回失败的数量
+func (dm *DLMaster) RunSelfTest(fixturesDir string, names []string) int {
+	fixtures, errs := loadFixtures(fixturesDir)
+	failed := len(errs)
+	for _, err := range errs {
+		fmt.Printf("FAIL %v\n", err)
+	}
+	for _, fixture := range fixtures {
+		if len(names) > 0 && !containsFold(names, fixture.Name) {
+			continue
+		}
+		note := ""
+		if fixture.Synthetic {
+			note = " (synthetic)"
+		}
+		for _, path := range fixture.Paths {
+			if err := dm.replayFixture(fixture, path); err != nil {
+				fmt.Printf("FAIL %s [%s]%s: %v\n", fixture.Name, path, note, err)
+				failed++
+			} else {
+				fmt.Printf("ok   %s [%s]%s\n", fixture.Name, path, note)
+			}
+		}
+	}
+	if len(names) == 0 {
+		for _, name := range dm.untestedSites(fixtures) {
+			fmt.Printf("FAIL %s: no fixture recorded\n", name)
+			failed++
+		}
+	}
+	return failed
+}
+
+func (dm *DLMaster) replayFixture(fixture *Fixture, path string) error {
+	rs, err := newReplayServer(fixture.dir, fixture)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	dm.browserPool.mu.Lock()
+	dm.browserPool.allocOptions = []chromedp.ExecAllocatorOption{chromedp.Flag("ignore-certificate-errors", true)}
+	dm.browserPool.mu.Unlock()
+	restore := useFixtureProxy(rs.ProxyURL(), path)
+	defer restore()
+
+	videoMeta, err := dm.FetchVideoMeta(fixture.URL)
+	if fixture.Expect.Error != "" {
+		if err == nil || !strings.Contains(err.Error(), fixture.Expect.Error) {
+			return fmt.Errorf("error = %v, want %q", err, fixture.Expect.Error)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if videoMeta.Title != fixture.Expect.Title {
+		errs = append(errs, fmt.Sprintf("title = %q, want %q", videoMeta.Title, fixture.Expect.Title))
+	}
+	if videoMeta.M3u8URL != fixture.Expect.M3u8URL {
+		errs = append(errs, fmt.Sprintf("m3u8 = %q, want %q", videoMeta.M3u8URL, fixture.Expect.M3u8URL))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// useFixtureProxy 让浏览器和 http.Client 都通过回放代理访问，返回恢复函数
+func useFixtureProxy(proxyURL string, path string) func() {
+	oldProxy, oldClient, oldForce := globalProxyConfig, sharedHttpClient, forceBrowserExtractor
+	globalProxyConfig = &ProxyConfig{Default: proxyURL}
+	client := NewHttpClient(DOWNLOAD_WORKERS)
+	// 回放服务器使用自签名证书
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	sharedHttpClient = client
+	forceBrowserExtractor = path == extractPathBrowser
+	return func() {
+		globalProxyConfig, sharedHttpClient, forceBrowserExtractor = oldProxy, oldClient, oldForce
+	}
+}
+
+// replayServer 作为 HTTP 代理回放录制的响应：http 请求直接回放，
+// https 的 CONNECT 请求转发到一个 TLS 服务器，由它按原始 Host 回放
+type replayServer struct {
+	dir       string
+	responses map[string]FixtureResponse
+	tlsServer *httptest.Server
+	proxy     *httptest.Server
+}
+
+func newReplayServer(dir string, fixture *Fixture) (*replayServer, error) {
+	rs := &replayServer{dir: dir, responses: make(map[string]FixtureResponse)}
+	for _, r := range fixture.Responses {
+		// 同一个地址只回放第一次的响应
+		if _, ok := rs.responses[r.URL]; !ok {
+			rs.responses[r.URL] = r
+		}
+	}
+	rs.tlsServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.serve(w, "https://"+r.Host+r.URL.RequestURI())
+	}))
+	rs.proxy = httptest.NewServer(http.HandlerFunc(rs.serveProxy))
+	return rs, nil
+}
+
+func (rs *replayServer) ProxyURL() string {
+	return rs.proxy.URL
+}
+
+func (rs *replayServer) Close() {
+	rs.proxy.Close()
+	rs.tlsServer.Close()
+}
+
+func (rs *replayServer) serveProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		rs.serve(w, r.URL.String())
+		return
+	}
+	upstream, err := net.Dial("tcp", rs.tlsServer.Listener.Addr().String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "hijack not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+	_ = conn.Close()
+}
+
+func (rs *replayServer) serve(w http.ResponseWriter, rawURL string) {
+	res, ok := rs.responses[rawURL]
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	body, err := os.ReadFile(filepath.Join(rs.dir, res.File))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res.ContentType != "" {
+		w.Header().Set("Content-Type", res.ContentType)
+	}
+	// 录制时没有保存响应头，回放时允许页面脚本跨域请求 playlist
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(res.Status)
+	_, _ = w.Write(body)
+}
+
+// RecordFixture 访问真实站点，录制纯 HTTP 和浏览器两种方式的所有响应，
+// 以当前提取结果作为期望值写入 <fixturesDir>/<name>
+func (dm *DLMaster) RecordFixture(fixturesDir, name, videoURL string) error {
+	dir := filepath.Join(fixturesDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	rec := &fixtureRecorder{dir: dir, seen: make(map[string]bool)}
+	oldClient, oldForce, oldHook := sharedHttpClient, forceBrowserExtractor, onBrowserTab
+	defer func() {
+		sharedHttpClient, forceBrowserExtractor, onBrowserTab = oldClient, oldForce, oldHook
+	}()
+	sharedHttpClient = &http.Client{Transport: &recordingTransport{next: oldClient.Transport, rec: rec}}
+	onBrowserTab = rec.listenBrowser
+
+	fixture := &Fixture{Name: name, URL: videoURL}
+	for _, path := range []string{extractPathHTTP, extractPathBrowser} {
+		forceBrowserExtractor = path == extractPathBrowser
+		videoMeta, err := dm.FetchVideoMeta(videoURL)
+		if err != nil {
+			slog.Warn("No metadata extracted while recording", "fixture", name, "path", path, "error", err)
+			continue
+		}
+		if len(fixture.Paths) == 0 {
+			fixture.Expect = FixtureExpect{Title: videoMeta.Title, M3u8URL: videoMeta.M3u8URL}
+		} else if videoMeta.M3u8URL != fixture.Expect.M3u8URL || videoMeta.Title != fixture.Expect.Title {
+			// 两种方式结果不同（例如 m3u8 带时间戳签名）时只保留第一种
+			slog.Warn("Recorded result differs from the first path, skipped", "fixture", name, "path", path, "first", fixture.Paths[0])
+			continue
+		}
+		fixture.Paths = append(fixture.Paths, path)
+	}
+	if len(fixture.Paths) == 0 {
+		return fmt.Errorf("failed to extract metadata from %s", videoURL)
+	}
+	// 每个标签页关闭前已等待其响应体读取完成
+	fixture.Responses = rec.responses()
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	slog.Info("Fixture recorded", "fixture", name, "responses", len(fixture.Responses), "dir", dir)
+	return os.WriteFile(filepath.Join(dir, "fixture.json"), data, 0644)
+}
+
+type fixtureRecorder struct {
+	dir  string
+	mu   sync.Mutex
+	list []FixtureResponse
+	seen map[string]bool
+}
+
+func (fr *fixtureRecorder) add(rawURL string, status int, contentType string, body []byte) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.seen[rawURL] {
+		return
+	}
+	fr.seen[rawURL] = true
+	file := fmt.Sprintf("%04d.body", len(fr.list)+1)
+	if err := os.WriteFile(filepath.Join(fr.dir, file), body, 0644); err != nil {
+		slog.Error("Failed to save fixture body", "url", rawURL, "error", err)
+		return
+	}
+	fr.list = append(fr.list, FixtureResponse{URL: rawURL, Status: status, ContentType: contentType, File: file})
+}
+
+func (fr *fixtureRecorder) responses() []FixtureResponse {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return append([]FixtureResponse(nil), fr.list...)
+}
+
+// listenBrowser 录制浏览器标签页中所有已完成的响应，返回的函数等待正在读取的响应体，
+// 需要在标签页关闭前调用，否则未完成的 GetResponseBody 会被取消
+func (fr *fixtureRecorder) listenBrowser(ctx context.Context) func() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	closed := false
+	received := make(map[network.RequestID]*network.Response)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventResponseReceived:
+			mu.Lock()
+			received[ev.RequestID] = ev.Response
+			mu.Unlock()
+		case *network.EventLoadingFinished:
+			mu.Lock()
+			res, ok := received[ev.RequestID]
+			if !ok || closed || strings.HasPrefix(res.URL, "data:") {
+				mu.Unlock()
+				return
+			}
+			wg.Add(1)
+			mu.Unlock()
+			go func() {
+				defer wg.Done()
+				var body []byte
+				err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+					var err error
+					body, err = network.GetResponseBody(ev.RequestID).Do(ctx)
+					return err
+				}))
+				if err == nil {
+					fr.add(res.URL, int(res.Status), res.MimeType, body)
+				} else {
+					slog.Warn("Failed to record response body", "url", res.URL, "error", err)
+				}
+			}()
+		}
+	})
+	return func() {
+		mu.Lock()
+		closed = true
+		mu.Unlock()
+		wg.Wait()
+	}
+}
+
+// recordingTransport 录制 http.Client 的所有响应
+type recordingTransport struct {
+	next http.RoundTripper
+	rec  *fixtureRecorder
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.rec.add(req.URL.String(), res.StatusCode, res.Header.Get("Content-Type"), body)
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+const testFixturesDir = "testdata/sites"
+
+// chromeAvailable 与 chromedp 查找 Chrome 的名称一致
+func chromeAvailable() bool {
+	for _, name := range []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "google-chrome-beta", "google-chrome-unstable"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSiteFixtures(t *testing.T) {
+	dm := NewDLMaster(1)
+	defer dm.Close()
+	if err := dm.RegisterBuiltinSites(); err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, errs := loadFixtures(testFixturesDir)
+	for _, err := range errs {
+		t.Errorf("load fixture: %v", err)
+	}
+	for _, name := range dm.untestedSites(fixtures) {
+		t.Errorf("site %s has no fixture in %s", name, testFixturesDir)
+	}
+
+	hasChrome := chromeAvailable()
+	for _, fixture := range fixtures {
+		for _, path := range fixture.Paths {
+			t.Run(fixture.Name+"/"+path, func(t *testing.T) {
+				if path == extractPathBrowser && !hasChrome {
+					t.Skip("chrome not found")
+				}
+				if err := dm.replayFixture(fixture, path); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+func TestUntestedSitesReportsMissingFixtures(t *testing.T) {
+	dm := NewDLMaster(1)
+	defer dm.Close()
+	if err := dm.RegisterBuiltinSites(); err != nil {
+		t.Fatal(err)
+	}
+	fixtures, _ := loadFixtures(testFixturesDir)
+	var withoutJable []*Fixture
+	for _, fixture := range fixtures {
+		if fixture.Name != "jabletv" {
+			withoutJable = append(withoutJable, fixture)
+		}
+	}
+	missing := dm.untestedSites(withoutJable)
+	if len(missing) != 1 || missing[0] != funcName(FetchJableTVVideoMeta) {
+		t.Errorf("untestedSites = %v, want [%s]", missing, funcName(FetchJableTVVideoMeta))
+	}
+}
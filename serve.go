@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DEFAULT_LISTEN serve 模式默认只监听本机，局域网访问需要 -listen :8080
+	DEFAULT_LISTEN = "127.0.0.1:8080"
+	// SERVER_SHUTDOWN_TIMEOUT 退出时等待正在处理的请求的时间
+	SERVER_SHUTDOWN_TIMEOUT = 5 * time.Second
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobState    = errors.New("invalid job state")
+	ErrNoJobStore  = errors.New("job store is not configured")
+)
+
+// urlQueue serve 模式的待处理地址，不限长度，提交时不会阻塞
+type urlQueue struct {
+	mu     sync.Mutex
+	items  []string
+	queued map[string]bool
+	notify chan struct{}
+}
+
+func newURLQueue() *urlQueue {
+	return &urlQueue{queued: make(map[string]bool), notify: make(chan struct{}, 1)}
+}
+
+// Push 已在队列中的地址不会重复添加
+func (q *urlQueue) Push(vURL string) {
+	q.mu.Lock()
+	if !q.queued[vURL] {
+		q.queued[vURL] = true
+		q.items = append(q.items, vURL)
+	}
+	q.mu.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run 按提交顺序发送到 out，ctx 结束时关闭 out
+func (q *urlQueue) run(ctx context.Context, out chan<- string) {
+	defer close(out)
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		vURL := q.items[0]
+		q.mu.Unlock()
+		select {
+		case out <- vURL:
+			q.mu.Lock()
+			q.items = q.items[1:]
+			delete(q.queued, vURL)
+			q.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Serve 持续处理提交的任务，启动时继续未完成的任务。ctx 结束后停止所有下载，状态保持不变，重启后继续
+func (dm *DLMaster) Serve(ctx context.Context) error {
+	if dm.store == nil {
+		return ErrNoJobStore
+	}
+	urls, err := dm.store.Unfinished()
+	if err != nil {
+		return err
+	}
+	for _, vURL := range urls {
+		dm.queue.Push(vURL)
+	}
+	go func() {
+		<-ctx.Done()
+		dm.StopAll()
+	}()
+	urlCh := make(chan string)
+	go dm.queue.run(ctx, urlCh)
+	dm.pipeline(urlCh)
+	return nil
+}
+
+// Submit 添加任务，title、headers 可以为空。已存在的任务保持原状态，失败或取消的任务重新排队
+func (dm *DLMaster) Submit(vURL, title string, headers http.Header) (*Job, error) {
+	if dm.store == nil {
+		return nil, ErrNoJobStore
+	}
+	job, err := dm.store.Enqueue(vURL, title, headers)
+	if err != nil {
+		return nil, err
+	}
+	if job.State == JobPending {
+		dm.jobLog(vURL, slog.LevelInfo, "Job queued")
+		dm.queue.Push(vURL)
+	}
+	return job, nil
+}
+
+// controlJob 按状态修改任务，当前状态不在 from 中时返回 ErrJobState。fn 可以为 nil，在修改状态前执行
+func (dm *DLMaster) controlJob(id, action string, to JobState, fn func(job *Job), from ...JobState) (*Job, error) {
+	if dm.store == nil {
+		return nil, ErrNoJobStore
+	}
+	job, err := dm.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	job, err = dm.store.UpdateIf(job.URL, func(job *Job) error {
+		for _, state := range from {
+			if job.State == state {
+				if fn != nil {
+					fn(job)
+				}
+				job.State = to
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: cannot %s a %s job", ErrJobState, action, job.State)
+	})
+	if err != nil {
+		return nil, err
+	}
+	dm.jobLog(job.URL, slog.LevelInfo, "Job state changed", "action", action, "state", job.State)
+	return job, nil
+}
+
+// Pause 暂停任务，已下载的分片保留，合并开始后无法暂停
+func (dm *DLMaster) Pause(id string) (*Job, error) {
+	job, err := dm.controlJob(id, "pause", JobPaused, nil, JobPending, JobFetchingMeta, JobDownloading)
+	if err != nil {
+		return nil, err
+	}
+	dm.stopDownload(job.URL)
+	return job, nil
+}
+
+// Resume 继续暂停的任务，元数据过期或上次因 playlist/元数据失败时重新获取
+func (dm *DLMaster) Resume(id string) (*Job, error) {
+	job, err := dm.controlJob(id, "resume", JobPending, func(job *Job) {
+		if !job.MetaUsable() {
+			job.ClearMeta()
+		}
+	}, JobPaused)
+	if err != nil {
+		return nil, err
+	}
+	dm.queue.Push(job.URL)
+	return job, nil
+}
+
+// Cancel 取消任务并删除已下载的分片
+func (dm *DLMaster) Cancel(id string) (*Job, error) {
+	job, err := dm.controlJob(id, "cancel", JobCanceled, nil, JobPending, JobFetchingMeta, JobDownloading, JobPaused, JobFailed)
+	if err != nil {
+		return nil, err
+	}
+	dm.mu.Lock()
+	dl, running := dm.running[job.URL]
+	dm.mu.Unlock()
+	if running {
+		// download 停止后删除临时目录
+		dl.Stop()
+	} else if id := job.segmentVideoID(); id != "" {
+		_ = os.RemoveAll(segmentDir(id))
+	}
+	return job, nil
+}
+
+// Retry 重新下载失败或取消的任务，重新获取元数据（m3u8 地址和 cookie 多半已过期），失败的任务会从已下载的分片继续
+func (dm *DLMaster) Retry(id string) (*Job, error) {
+	job, err := dm.controlJob(id, "retry", JobPending, (*Job).ClearMeta, JobFailed, JobCanceled)
+	if err != nil {
+		return nil, err
+	}
+	dm.queue.Push(job.URL)
+	return job, nil
+}
+
+// runServer 启动 HTTP 接口并处理任务，收到 SIGINT/SIGTERM 后停止下载并退出
+func runServer(dm *DLMaster, addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SSE 连接随 ctx 结束，否则 Shutdown 会一直等待
+	srv := &http.Server{Addr: addr, Handler: NewAPIHandler(dm), BaseContext: func(net.Listener) context.Context { return ctx }}
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("Serving web UI", "url", "http://"+addr+"/")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+			stop()
+		}
+	}()
+	doneCh := make(chan error, 1)
+	go func() { doneCh <- dm.Serve(ctx) }()
+
+	<-ctx.Done()
+	slog.Info("Shutting down, unfinished jobs will resume on next start")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	if err := <-doneCh; err != nil {
+		return err
+	}
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Delete 删除已结束的任务及其日志和剩余的分片，不删除下载的视频
+func (dm *DLMaster) Delete(id string) error {
+	job, err := dm.getJob(id)
+	if err != nil {
+		return err
+	}
+	if !job.Finished() {
+		return fmt.Errorf("%w: cannot delete a %s job, pause or cancel it first", ErrJobState, job.State)
+	}
+	if err := dm.store.Delete(id); err != nil {
+		return err
+	}
+	if id := job.segmentVideoID(); id != "" {
+		_ = os.RemoveAll(segmentDir(id))
+	}
+	dm.events.Deleted(id)
+	return nil
+}
+
+// apiJob 接口和 SSE 返回的任务，附带下载进度。接口没有认证，不包含请求头、cookie 和元数据，
+// 避免泄露提交或抓取到的 Authorization、带 token 的 Referer 和 cookie
+type apiJob struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	State     JobState   `json:"state"`
+	Title     string     `json:"title,omitempty"` // 提交时指定的标题，没有时为站点提取的标题
+	Output    string     `json:"output,omitempty"`
+	Segments  int        `json:"segments"`
+	Completed int        `json:"completed"`
+	Progress  float64    `json:"progress"`        // 0~1
+	Error     string     `json:"error,omitempty"` // 最近一次错误
+	Errors    []JobError `json:"errors,omitempty"`
+	Created   time.Time  `json:"created"`
+	Updated   time.Time  `json:"updated"`
+}
+
+func newAPIJob(job *Job) apiJob {
+	v := apiJob{
+		ID:        job.ID,
+		URL:       job.URL,
+		State:     job.State,
+		Title:     job.Title,
+		Output:    job.Output,
+		Segments:  job.Segments,
+		Completed: job.Completed,
+		Errors:    job.Errors,
+		Created:   job.Created,
+		Updated:   job.Updated,
+	}
+	if v.Title == "" && job.Meta != nil {
+		v.Title = job.Meta.Title
+	}
+	if n := len(job.Errors); n > 0 {
+		v.Error = job.Errors[n-1].Message
+	}
+	if job.Segments > 0 {
+		v.Progress = float64(job.Completed) / float64(job.Segments)
+	}
+	if job.State == JobDone {
+		v.Progress = 1
+	}
+	return v
+}
+
+// submitRequest POST /api/jobs 的请求体
+type submitRequest struct {
+	URL     string            `json:"url"`
+	Title   string            `json:"title"`
+	Headers map[string]string `json:"headers"`
+}
+
+// NewAPIHandler serve 模式的 HTTP/JSON 接口
+//
+//	POST /api/jobs                 {"url": "...", "title": "...", "headers": {"Referer": "..."}}
+//	GET  /api/jobs[?state=...]     任务列表及进度
+//	GET  /api/jobs/{id}
+//	POST /api/jobs/{id}/pause|resume|cancel|retry
+//	GET  /api/jobs/{id}/logs[?tail=100]
+//	DELETE /api/jobs/{id}          删除已结束的任务
+//	GET  /api/events               任务更新（SSE）
+//	GET  /metrics                  Prometheus 指标
+//	GET|PUT /api/limits            查看或修改限速、每个 host 的连接数和 worker 总数
+//	GET  /                         内嵌的网页
+//
+// 修改类请求（POST、PUT、DELETE）必须带 Content-Type: application/json，其他网站的页面无法在
+// 没有 CORS 预检的情况下发出这种请求，避免浏览器访问恶意页面时被跨站提交或删除任务
+func NewAPIHandler(dm *DLMaster) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /", webHandler())
+	mux.HandleFunc("GET /api/events", dm.handleEvents)
+	mux.HandleFunc("GET /metrics", dm.handleMetrics)
+	mux.HandleFunc("POST /api/jobs", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		var req submitRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid url %q", req.URL))
+			return
+		}
+		headers := http.Header{}
+		for k, v := range req.Headers {
+			headers.Set(k, v)
+		}
+		job, err := dm.Submit(req.URL, strings.TrimSpace(req.Title), headers)
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, newAPIJob(job))
+	}))
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if dm.store == nil {
+			writeAPIError(w, http.StatusServiceUnavailable, ErrNoJobStore)
+			return
+		}
+		jobs, err := dm.store.List()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		state := JobState(r.URL.Query().Get("state"))
+		list := make([]apiJob, 0, len(jobs))
+		for _, job := range jobs {
+			if state == "" || job.State == state {
+				list = append(list, newAPIJob(job))
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("GET /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, err := dm.getJob(r.PathValue("id"))
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIJob(job))
+	})
+	actions := map[string]func(id string) (*Job, error){
+		"pause":  dm.Pause,
+		"resume": dm.Resume,
+		"cancel": dm.Cancel,
+		"retry":  dm.Retry,
+	}
+	mux.HandleFunc("POST /api/jobs/{id}/{action}", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		fn, ok := actions[r.PathValue("action")]
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", r.PathValue("action")))
+			return
+		}
+		job, err := fn(r.PathValue("id"))
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIJob(job))
+	}))
+	mux.HandleFunc("DELETE /api/jobs/{id}", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		if err := dm.Delete(r.PathValue("id")); err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("GET /api/jobs/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		job, err := dm.getJob(r.PathValue("id"))
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		lines, err := dm.store.Logs(job.ID)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if tail, err := strconv.Atoi(r.URL.Query().Get("tail")); err == nil && tail >= 0 && tail < len(lines) {
+			lines = lines[len(lines)-tail:]
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	})
+	mux.HandleFunc("GET /api/limits", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, newAPILimits())
+	})
+	mux.HandleFunc("PUT /api/limits", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		var req limitsRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		cfg := CurrentLimits()
+		if req.LimitRate != nil {
+			cfg.LimitRate = *req.LimitRate
+		}
+		if req.HostConns != nil {
+			cfg.HostConns = *req.HostConns
+		}
+		if req.Workers != nil {
+			cfg.Workers = *req.Workers
+		}
+		if cfg.HostConns < 0 || cfg.Workers < 0 {
+			writeAPIError(w, http.StatusBadRequest, errors.New("host_conns and workers must not be negative"))
+			return
+		}
+		if err := cfg.ApplyLimits(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPILimits())
+	}))
+	return mux
+}
+
+// requireJSON 拒绝 Content-Type 不是 application/json 的请求（表单、text/plain 等可以跨站发送的类型）
+func requireJSON(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeAPIError(w, http.StatusUnsupportedMediaType, errors.New("request Content-Type must be application/json"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// apiLimits GET/PUT /api/limits 的响应，rate 为 bytes/s，0 表示不限制
+type apiLimits struct {
+	Rate      int64 `json:"rate"`
+	HostConns int   `json:"host_conns"`
+	Workers   int   `json:"workers"`
+}
+
+func newAPILimits() apiLimits {
+	return apiLimits{Rate: globalRateLimiter.Rate(), HostConns: globalHostLimiter.Limit(), Workers: globalWorkerBudget.Capacity()}
+}
+
+// limitsRequest PUT /api/limits 的请求体，未指定的字段保持不变，limit_rate 与配置文件格式相同
+type limitsRequest struct {
+	LimitRate *string `json:"limit_rate"`
+	HostConns *int    `json:"host_conns"`
+	Workers   *int    `json:"workers"`
+}
+
+func (dm *DLMaster) getJob(id string) (*Job, error) {
+	if dm.store == nil {
+		return nil, ErrNoJobStore
+	}
+	job, err := dm.store.GetByID(id)
+	if err == nil && job == nil {
+		err = ErrJobNotFound
+	}
+	return job, err
+}
+
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrJobState):
+		return http.StatusConflict
+	case errors.Is(err, ErrNoJobStore):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const stubSiteURL = "https://stub.test/"
+
+// newTestAPI 使用临时任务数据库和不启动浏览器的 stub 站点，serve 为 true 时同时运行任务流水线
+func newTestAPI(t *testing.T, serve bool, fetch fetchVideoMetaFunc) (*httptest.Server, *DLMaster) {
+	t.Helper()
+	js, err := OpenJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDLMaster(1)
+	dm.SetJobStore(js)
+	if err := dm.RegisterVideoHandle(stubSiteURL, fetch); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewAPIHandler(dm))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	if serve {
+		go func() {
+			defer close(done)
+			if err := dm.Serve(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	} else {
+		close(done)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		<-done
+		dm.Close()
+		_ = js.Close()
+	})
+	return srv, dm
+}
+
+func stubUnsupported(_ *BrowserPool, videoURL string) (*VideoMeta, error) {
+	return nil, fmt.Errorf("%w: stub %s", ErrUnsupportedSite, videoURL)
+}
+
+// apiCall 发送请求，修改类请求带 JSON Content-Type，返回状态码和响应内容
+func apiCall(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(data)
+}
+
+func decodeAPIJob(t *testing.T, body string) apiJob {
+	t.Helper()
+	var job apiJob
+	if err := json.Unmarshal([]byte(body), &job); err != nil {
+		t.Fatalf("decode job %q: %v", body, err)
+	}
+	return job
+}
+
+func submitTestJob(t *testing.T, srv *httptest.Server, videoURL string) apiJob {
+	t.Helper()
+	status, body := apiCall(t, srv, http.MethodPost, "/api/jobs", fmt.Sprintf(`{"url": %q, "title": "Stub", "headers": {"Referer": "https://stub.test/"}}`, videoURL))
+	if status != http.StatusAccepted {
+		t.Fatalf("POST /api/jobs = %d %s", status, body)
+	}
+	return decodeAPIJob(t, body)
+}
+
+func TestAPISubmitAndList(t *testing.T) {
+	srv, dm := newTestAPI(t, false, stubUnsupported)
+
+	job := submitTestJob(t, srv, stubSiteURL+"v/1")
+	if job.State != JobPending || job.Title != "Stub" {
+		t.Fatalf("submitted job = %+v", job)
+	}
+	if stored, err := dm.store.Get(job.URL); err != nil || stored.Headers.Get("Referer") != "https://stub.test/" {
+		t.Fatalf("stored job = %+v, %v", stored, err)
+	}
+	// 重复提交返回同一个任务
+	if again := submitTestJob(t, srv, stubSiteURL+"v/1"); again.ID != job.ID {
+		t.Errorf("resubmitted job id = %s, want %s", again.ID, job.ID)
+	}
+	submitTestJob(t, srv, stubSiteURL+"v/2")
+
+	status, body := apiCall(t, srv, http.MethodGet, "/api/jobs", "")
+	var list []apiJob
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &list) != nil || len(list) != 2 {
+		t.Fatalf("GET /api/jobs = %d %s", status, body)
+	}
+	status, body = apiCall(t, srv, http.MethodGet, "/api/jobs?state=done", "")
+	if status != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("GET /api/jobs?state=done = %d %s", status, body)
+	}
+
+	status, body = apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID, "")
+	if status != http.StatusOK || decodeAPIJob(t, body).URL != stubSiteURL+"v/1" {
+		t.Errorf("GET /api/jobs/{id} = %d %s", status, body)
+	}
+	if status, _ := apiCall(t, srv, http.MethodGet, "/api/jobs/missing", ""); status != http.StatusNotFound {
+		t.Errorf("GET unknown job = %d, want 404", status)
+	}
+}
+
+func TestAPISubmitRejectsInvalidRequests(t *testing.T) {
+	srv, _ := newTestAPI(t, false, stubUnsupported)
+
+	for _, body := range []string{`{"url": "ftp://stub.test/v/1"}`, `{"url": `, `{}`} {
+		if status, resp := apiCall(t, srv, http.MethodPost, "/api/jobs", body); status != http.StatusBadRequest {
+			t.Errorf("POST %s = %d %s, want 400", body, status, resp)
+		}
+	}
+	// 表单和 text/plain 可以跨站提交，必须拒绝
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/jobs", strings.NewReader(`{"url": "https://stub.test/v/1"}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("POST with Content-Type %q = %d, want 415", contentType, res.StatusCode)
+		}
+	}
+	status, body := apiCall(t, srv, http.MethodGet, "/api/jobs", "")
+	if status != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("rejected requests created jobs: %s", body)
+	}
+}
+
+func TestAPIJobActions(t *testing.T) {
+	srv, _ := newTestAPI(t, false, stubUnsupported)
+	job := submitTestJob(t, srv, stubSiteURL+"v/1")
+
+	steps := []struct {
+		method, path string
+		status       int
+		state        JobState
+	}{
+		{http.MethodPost, "/pause", http.StatusOK, JobPaused},
+		{http.MethodPost, "/pause", http.StatusConflict, JobPaused},
+		{http.MethodPost, "/resume", http.StatusOK, JobPending},
+		{http.MethodDelete, "", http.StatusConflict, JobPending}, // 未结束的任务不能删除
+		{http.MethodPost, "/retry", http.StatusConflict, JobPending},
+		{http.MethodPost, "/cancel", http.StatusOK, JobCanceled},
+		{http.MethodPost, "/resume", http.StatusConflict, JobCanceled},
+		{http.MethodPost, "/retry", http.StatusOK, JobPending},
+		{http.MethodPost, "/cancel", http.StatusOK, JobCanceled},
+		{http.MethodPost, "/restart", http.StatusNotFound, JobCanceled},
+	}
+	for _, step := range steps {
+		path := "/api/jobs/" + job.ID + step.path
+		status, body := apiCall(t, srv, step.method, path, "")
+		if status != step.status {
+			t.Fatalf("%s %s = %d %s, want %d", step.method, path, status, body, step.status)
+		}
+		_, body = apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID, "")
+		if got := decodeAPIJob(t, body).State; got != step.state {
+			t.Fatalf("after %s %s state = %s, want %s", step.method, path, got, step.state)
+		}
+	}
+
+	status, logs := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID+"/logs", "")
+	if status != http.StatusOK || !strings.Contains(logs, "Job queued") || !strings.Contains(logs, "action=pause") {
+		t.Errorf("GET logs = %d %s", status, logs)
+	}
+	_, tail := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID+"/logs?tail=1", "")
+	if lines := strings.Split(strings.TrimSpace(tail), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "action=cancel") {
+		t.Errorf("GET logs?tail=1 = %q", tail)
+	}
+
+	if status, body := apiCall(t, srv, http.MethodDelete, "/api/jobs/"+job.ID, ""); status != http.StatusNoContent {
+		t.Fatalf("DELETE = %d %s", status, body)
+	}
+	if status, _ := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID, ""); status != http.StatusNotFound {
+		t.Errorf("GET deleted job = %d, want 404", status)
+	}
+	if status, _ := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID+"/logs", ""); status != http.StatusNotFound {
+		t.Errorf("GET deleted job logs = %d, want 404", status)
+	}
+}
+
+// waitJobState 等待流水线处理任务
+func waitJobState(t *testing.T, srv *httptest.Server, id string, state JobState) apiJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := apiCall(t, srv, http.MethodGet, "/api/jobs/"+id, "")
+		job := decodeAPIJob(t, body)
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s state = %s, want %s", id, job.State, state)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAPIServeFailsAndRetries(t *testing.T) {
+	var calls int
+	fetched := make(chan struct{}, 4)
+	srv, _ := newTestAPI(t, true, func(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
+		calls++
+		fetched <- struct{}{}
+		return stubUnsupported(bp, videoURL)
+	})
+
+	job := submitTestJob(t, srv, stubSiteURL+"v/1")
+	failed := waitJobState(t, srv, job.ID, JobFailed)
+	if len(failed.Errors) != 1 || !strings.Contains(failed.Error, "stub") || failed.Errors[0].State != JobFetchingMeta {
+		t.Errorf("failed job errors = %+v", failed.Errors)
+	}
+
+	if status, body := apiCall(t, srv, http.MethodPost, "/api/jobs/"+job.ID+"/retry", ""); status != http.StatusOK {
+		t.Fatalf("retry = %d %s", status, body)
+	}
+	<-fetched
+	<-fetched
+	failed = waitJobState(t, srv, job.ID, JobFailed)
+	if calls != 2 || len(failed.Errors) != 2 {
+		t.Errorf("after retry calls = %d, errors = %d", calls, len(failed.Errors))
+	}
+	_, logs := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID+"/logs", "")
+	if !strings.Contains(logs, "Failed to fetch video metadata") {
+		t.Errorf("logs = %s", logs)
+	}
+}
+
+func TestAPIRetryRefetchesMetadata(t *testing.T) {
+	srv, dm := newTestAPI(t, false, stubUnsupported)
+	job := submitTestJob(t, srv, stubSiteURL+"v/1")
+	err := dm.store.Update(job.URL, func(j *Job) {
+		j.Meta, j.MetaTime, j.VideoID = &VideoMeta{URL: j.URL, VideoID: "stub"}, time.Now(), "stub"
+		j.State = JobFailed
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := apiCall(t, srv, http.MethodPost, "/api/jobs/"+job.ID+"/retry", ""); status != http.StatusOK {
+		t.Fatalf("retry = %d %s", status, body)
+	}
+	stored, err := dm.store.Get(job.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Meta != nil || stored.VideoID != "stub" {
+		t.Errorf("after retry meta = %+v, video id = %q, want no meta and the video id kept", stored.Meta, stored.VideoID)
+	}
+}
+
+func TestAPILimits(t *testing.T) {
+	srv, _ := newTestAPI(t, false, stubUnsupported)
+	old := CurrentLimits()
+	t.Cleanup(func() { _ = old.ApplyLimits() })
+
+	status, body := apiCall(t, srv, http.MethodPut, "/api/limits", `{"limit_rate": "1M", "host_conns": 3}`)
+	var limits apiLimits
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &limits) != nil {
+		t.Fatalf("PUT /api/limits = %d %s", status, body)
+	}
+	if limits.Rate != 1024*1024 || limits.HostConns != 3 {
+		t.Errorf("limits = %+v", limits)
+	}
+	if status, _ := apiCall(t, srv, http.MethodPut, "/api/limits", `{"limit_rate": "fast"}`); status != http.StatusBadRequest {
+		t.Errorf("PUT invalid rate = %d, want 400", status)
+	}
+	if status, _ := apiCall(t, srv, http.MethodPut, "/api/limits", `{"workers": -1}`); status != http.StatusBadRequest {
+		t.Errorf("PUT negative workers = %d, want 400", status)
+	}
+	_, body = apiCall(t, srv, http.MethodGet, "/api/limits", "")
+	if json.Unmarshal([]byte(body), &limits) != nil || limits.HostConns != 3 {
+		t.Errorf("GET /api/limits = %s", body)
+	}
+}
+
+func TestAPIJobsOmitHeadersAndCookies(t *testing.T) {
+	srv, dm := newTestAPI(t, false, stubUnsupported)
+	secrets := []string{"submitted-referer-token", "captured-auth-token", "captured-cookie-value"}
+	status, body := apiCall(t, srv, http.MethodPost, "/api/jobs", `{"url": "https://stub.test/v/1", "headers": {"Referer": "https://stub.test/?t=`+secrets[0]+`"}}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("POST /api/jobs = %d %s", status, body)
+	}
+	job := decodeAPIJob(t, body)
+	err := dm.store.Update(job.URL, func(j *Job) {
+		j.Meta = &VideoMeta{
+			URL:     j.URL,
+			Title:   "Captured",
+			Headers: http.Header{"Authorization": {"Bearer " + secrets[1]}},
+			Cookies: []*http.Cookie{{Name: "session", Value: secrets[2]}},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SSE 先推送完整列表，修改任务后推送单个任务
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/events", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	events := make(chan string, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+	}()
+	sse := []string{<-events}
+	if status, body := apiCall(t, srv, http.MethodPost, "/api/jobs/"+job.ID+"/pause", ""); status != http.StatusOK {
+		t.Fatalf("pause = %d %s", status, body)
+	}
+	for data := range events {
+		sse = append(sse, data)
+		if strings.Contains(data, `"state":"paused"`) {
+			break
+		}
+	}
+	if len(sse) < 2 {
+		t.Fatalf("SSE events = %q, want the list and the paused job", sse)
+	}
+
+	_, list := apiCall(t, srv, http.MethodGet, "/api/jobs", "")
+	_, single := apiCall(t, srv, http.MethodGet, "/api/jobs/"+job.ID, "")
+	outputs := append([]string{list, single}, sse...)
+	for _, out := range outputs {
+		for _, secret := range secrets {
+			if strings.Contains(out, secret) {
+				t.Errorf("API output exposes %q: %s", secret, out)
+			}
+		}
+		if !strings.Contains(out, "Captured") {
+			t.Errorf("API output misses the extracted title: %s", out)
+		}
+	}
+}
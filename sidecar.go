@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	sidecarLayoutFile   = "file"   // <name>.nfo、<name>-poster.jpg、<name>-fanart.jpg，多个视频共用一个目录
+	sidecarLayoutFolder = "folder" // movie.nfo、poster.jpg、fanart.jpg，要求输出模板为每个视频单独建一个目录
+)
+
+// globalSidecarConfig 媒体库 sidecar 文件配置，默认不生成
+var globalSidecarConfig = &SidecarConfig{}
+
+// SidecarConfig 生成 Kodi/Jellyfin 可以识别的 NFO 和海报
+type SidecarConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Layout   string `json:"layout"`   // file（默认）或 folder
+	Template string `json:"template"` // 自定义 NFO 模板文件（text/template），为空时使用内置模板
+}
+
+// defaultNFOTemplate Kodi movie.nfo 格式，字段参考 https://kodi.wiki/view/NFO_files/Movies
+const defaultNFOTemplate = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<movie>
+  <title>{{xml .Title}}</title>
+{{- if .Code}}
+  <originaltitle>{{xml .Code}}</originaltitle>
+  <uniqueid type="code" default="true">{{xml .Code}}</uniqueid>
+{{- end}}
+{{- if .Description}}
+  <plot>{{xml .Description}}</plot>
+{{- end}}
+{{- if .Runtime}}
+  <runtime>{{.Runtime}}</runtime>
+{{- end}}
+{{- if .ReleaseDate}}
+  <premiered>{{xml .ReleaseDate}}</premiered>
+{{- end}}
+{{- if .Year}}
+  <year>{{.Year}}</year>
+{{- end}}
+{{- if .Studio}}
+  <studio>{{xml .Studio}}</studio>
+{{- end}}
+{{- range .Tags}}
+  <tag>{{xml .}}</tag>
+  <genre>{{xml .}}</genre>
+{{- end}}
+{{- range $i, $name := .Actors}}
+  <actor>
+    <name>{{xml $name}}</name>
+    <order>{{$i}}</order>
+  </actor>
+{{- end}}
+{{- if .Poster}}
+  <thumb aspect="poster">{{xml .Poster}}</thumb>
+  <fanart>
+    <thumb>{{xml .Fanart}}</thumb>
+  </fanart>
+{{- end}}
+  <website>{{xml .URL}}</website>
+</movie>
+`
+
+// sidecarData NFO 模板可以使用 VideoMeta 的所有字段，以及以下字段
+type sidecarData struct {
+	*VideoMeta
+	Runtime int    // 时长（分钟）
+	Year    string // 发行年份
+	Poster  string // 海报文件名，没有封面时为空
+	Fanart  string // 背景图文件名
+}
+
+// LoadTemplate 解析 NFO 模板，未指定模板文件时使用内置模板
+func (c *SidecarConfig) LoadTemplate() (*template.Template, error) {
+	text := defaultNFOTemplate
+	if c.Template != "" {
+		data, err := os.ReadFile(c.Template)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("nfo").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(text)
+}
+
+// Validate 启动时校验模板和目录布局：folder 布局的文件名固定，多个视频共用目录时会互相覆盖
+func (c *SidecarConfig) Validate(namer *OutputNamer) error {
+	switch c.Layout {
+	case "", sidecarLayoutFile:
+	case sidecarLayoutFolder:
+		if !namer.PerVideoDir() {
+			return fmt.Errorf("sidecar layout %q requires an output template with a directory per video, e.g. \"{id}/{title}.{ext}\"", c.Layout)
+		}
+	default:
+		return fmt.Errorf("unknown sidecar layout %q", c.Layout)
+	}
+	if _, err := c.LoadTemplate(); err != nil {
+		return fmt.Errorf("invalid nfo template: %w", err)
+	}
+	return nil
+}
+
+// readNFOIdentity 读取 NFO 中的来源地址和番号，用于判断已存在的视频文件属于哪个视频
+func readNFOIdentity(path string) (website, code string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var nfo struct {
+		Website   string `xml:"website"`
+		UniqueIDs []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"uniqueid"`
+	}
+	if err := xml.Unmarshal(data, &nfo); err != nil {
+		return "", "", err
+	}
+	for _, id := range nfo.UniqueIDs {
+		if id.Type == "code" {
+			code = strings.TrimSpace(id.Value)
+		}
+	}
+	return strings.TrimSpace(nfo.Website), code, nil
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// sidecarNames 返回 nfo、poster、fanart 的文件路径，perVideoDir 为 false 时即使是 folder 布局也使用视频文件名
+func (c *SidecarConfig) sidecarNames(dir, baseName, ext string, perVideoDir bool) (string, string, string) {
+	if c.Layout == sidecarLayoutFolder && perVideoDir {
+		return filepath.Join(dir, "movie.nfo"), filepath.Join(dir, "poster"+ext), filepath.Join(dir, "fanart"+ext)
+	}
+	return filepath.Join(dir, baseName+".nfo"), filepath.Join(dir, baseName+"-poster"+ext), filepath.Join(dir, baseName+"-fanart"+ext)
+}
+
+// writeSidecar 在视频旁边生成 NFO 和海报，cover 为 nil 时只生成 NFO
+func (md *M3u8Downloader) writeSidecar(cover []byte, ext string) error {
+	cfg := globalSidecarConfig
+	tmpl, err := cfg.LoadTemplate()
+	if err != nil {
+		return fmt.Errorf("load nfo template: %w", err)
+	}
+	if ext == "" {
+		ext = ".jpg"
+	}
+	nfoName, posterName, fanartName := cfg.sidecarNames(filepath.Dir(md.basePath()), filepath.Base(md.basePath()), ext, globalOutputNamer.PerVideoDir())
+
+	data := sidecarData{VideoMeta: md.videoMeta, Runtime: int(md.videoMeta.Duration.Minutes())}
+	if len(md.videoMeta.ReleaseDate) >= 4 {
+		data.Year = md.videoMeta.ReleaseDate[:4]
+	}
+	if cover != nil {
+		// Kodi 和 Jellyfin 都把背景图当作可选，没有单独的背景图时与海报相同
+		for _, name := range []string{posterName, fanartName} {
+			if err := os.WriteFile(name, cover, 0644); err != nil {
+				return err
+			}
+		}
+		data.Poster, data.Fanart = filepath.Base(posterName), filepath.Base(fanartName)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("render nfo: %w", err)
+	}
+	if err := os.WriteFile(nfoName, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	md.log(slog.LevelInfo, "NFO saved", "path", nfoName)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// SiteDefinition 配置文件中声明的站点，无需修改代码和重新编译即可支持新站点，例如：
+//
+//	{
+//	  "name": "hohoj",
+//	  "hosts": ["hohoj.tv"],
+//	  "title_meta": "description",
+//	  "m3u8_regex": "videoSrc\\s*[:=]\\s*['\"]([^'\"]+)['\"]",
+//	  "rewrite": [{"match": "^https://hohoj\\.tv/video\\?id=(\\w+).*$", "replace": "https://hohoj.tv/embed?id=$1"}],
+//	  "wait": {"click": [".play-button"], "timeout": "20s"}
+//	}
+type SiteDefinition struct {
+	Name          string            `json:"name"`
+	Hosts         []string          `json:"hosts"`          // host 匹配，同时匹配所有子域名
+	URLRegex      []string          `json:"url_regex"`      // 完整 URL 正则匹配
+	TitleMeta     string            `json:"title_meta"`     // 标题所在的 <meta name/property=...>
+	UseBrowser    bool              `json:"use_browser"`    // true 时跳过纯 HTTP 提取，直接使用浏览器
+	M3u8Regex     string            `json:"m3u8_regex"`     // 纯 HTTP 提取时匹配 m3u8 地址，第一个分组为结果
+	NetworkFilter string            `json:"network_filter"` // 浏览器抓取时只接受 URL 匹配该正则的 playlist 请求
+	Headers       map[string]string `json:"headers"`        // 下载 m3u8/key/ts 时附加的请求头
+	Rewrite       []URLRewrite      `json:"rewrite"`        // 抓取前改写页面地址，例如改为 iframe 地址
+	Wait          WaitStrategy      `json:"wait"`           // 浏览器抓取时的等待策略
+
+	HTTP    *HTTPExtractRule `json:"http,omitempty"`    // 更细的纯 HTTP 提取规则，优先于 title_meta/m3u8_regex
+	Details *DetailRule      `json:"details,omitempty"` // 封面、演员、标签等信息，纯 HTTP 和浏览器抓取共用
+}
+
+// URLRewrite 对页面地址做正则替换，replace 中可以使用 $1 引用分组
+type URLRewrite struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// WaitStrategy 页面就绪后依次执行：删除遮罩 -> 点击元素 -> 等待元素出现 -> 固定等待，然后等待 playlist
+type WaitStrategy struct {
+	Remove   []string `json:"remove"`   // 需要删除的遮罩元素
+	Click    []string `json:"click"`    // 需要点击的元素，例如播放按钮
+	Selector string   `json:"selector"` // 等待该元素出现
+	Sleep    string   `json:"sleep"`    // 固定等待时间，例如 "3s"
+	Timeout  string   `json:"timeout"`  // 等待 playlist 的超时时间，默认 CAPTURE_TIMEOUT
+}
+
+type siteFile struct {
+	Sites []SiteDefinition `json:"sites"`
+}
+
+// LoadSiteDefinitions 读取站点定义文件，支持 {"sites": [...]} 或直接是数组
+func LoadSiteDefinitions(path string) ([]SiteDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []SiteDefinition
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &defs)
+	} else {
+		var f siteFile
+		err = json.Unmarshal(data, &f)
+		defs = f.Sites
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse site definitions %s: %w", path, err)
+	}
+	return defs, nil
+}
+
+// Handler 将站点定义编译为 fetchVideoMetaFunc，正则和时间格式在这里校验
+func (sd *SiteDefinition) Handler() (fetchVideoMetaFunc, error) {
+	if len(sd.Hosts) == 0 && len(sd.URLRegex) == 0 {
+		return nil, fmt.Errorf("site %q: hosts and url_regex are both empty", sd.Name)
+	}
+	type rewriteRule struct {
+		reg     *regexp.Regexp
+		replace string
+	}
+	rewrites := make([]rewriteRule, 0, len(sd.Rewrite))
+	for _, rw := range sd.Rewrite {
+		reg, err := regexp.Compile(rw.Match)
+		if err != nil {
+			return nil, fmt.Errorf("site %q: invalid rewrite %q: %w", sd.Name, rw.Match, err)
+		}
+		rewrites = append(rewrites, rewriteRule{reg: reg, replace: rw.Replace})
+	}
+
+	rule := sd.HTTP
+	if rule == nil {
+		rule = &HTTPExtractRule{TitleMeta: sd.TitleMeta, M3u8Regex: sd.M3u8Regex}
+	}
+	if rule.Details == nil {
+		rule.Details = sd.Details
+	}
+	if rule.M3u8Regex != "" {
+		if _, err := regexp.Compile(rule.M3u8Regex); err != nil {
+			return nil, fmt.Errorf("site %q: invalid m3u8_regex: %w", sd.Name, err)
+		}
+	}
+
+	opts, err := sd.Wait.captureOptions()
+	if err != nil {
+		return nil, fmt.Errorf("site %q: %w", sd.Name, err)
+	}
+	opts.Details = rule.Details
+	if sd.NetworkFilter != "" {
+		if opts.NetworkFilter, err = regexp.Compile(sd.NetworkFilter); err != nil {
+			return nil, fmt.Errorf("site %q: invalid network_filter: %w", sd.Name, err)
+		}
+	}
+
+	headers := http.Header{}
+	for k, v := range sd.Headers {
+		headers.Set(k, v)
+	}
+	useBrowser, titleMeta := sd.UseBrowser, sd.TitleMeta
+
+	return func(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
+		for _, rw := range rewrites {
+			videoURL = rw.reg.ReplaceAllString(videoURL, rw.replace)
+		}
+		var videoMeta *VideoMeta
+		var err error
+		if useBrowser {
+			videoMeta, err = FetchVideoMeta(bp, videoURL, titleMeta, opts)
+		} else {
+			videoMeta, err = HTTPFirstFetchVideoMeta(bp, videoURL, titleMeta, rule, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if videoMeta.Headers == nil {
+			videoMeta.Headers = http.Header{}
+		}
+		for k, vs := range headers {
+			videoMeta.Headers[k] = vs
+		}
+		return videoMeta, nil
+	}, nil
+}
+
+func (ws WaitStrategy) captureOptions() (*CaptureOptions, error) {
+	opts := &CaptureOptions{}
+	for _, sel := range ws.Remove {
+		opts.Actions = append(opts.Actions, RemoveIfPresent(sel))
+	}
+	for _, sel := range ws.Click {
+		opts.Actions = append(opts.Actions, ClickIfPresent(sel))
+	}
+	if ws.Selector != "" {
+		opts.Actions = append(opts.Actions, chromedp.WaitVisible(ws.Selector, chromedp.ByQuery))
+	}
+	if ws.Sleep != "" {
+		d, err := time.ParseDuration(ws.Sleep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wait.sleep: %w", err)
+		}
+		opts.Actions = append(opts.Actions, chromedp.Sleep(d))
+	}
+	if ws.Timeout != "" {
+		d, err := time.ParseDuration(ws.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wait.timeout: %w", err)
+		}
+		opts.Timeout = d
+	}
+	return opts, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	matchKindHost  = "host"  // host 及其所有子域名，例如 jable.tv 同时匹配 www.jable.tv、en.jable.tv
+	matchKindRegex = "regex" // 完整 URL 正则
+)
+
+// siteMatcher 按注册顺序保存，后注册的优先，配置文件中的站点因此可以覆盖内置站点
+type siteMatcher struct {
+	name    string // handler 名称，用于 list-sites 展示
+	kind    string
+	pattern string
+	reg     *regexp.Regexp
+	fn      fetchVideoMetaFunc
+}
+
+// SiteMatch 匹配结果
+type SiteMatch struct {
+	Name    string
+	Kind    string
+	Pattern string
+	Reason  string
+	fn      fetchVideoMetaFunc
+}
+
+// SiteRegistry 站点 handler 注册表，支持通配子域名、完整 URL 正则和镜像域名别名
+type SiteRegistry struct {
+	matchers []*siteMatcher
+	aliases  []siteAlias // 按镜像域名长度从长到短排序，最具体的别名优先
+}
+
+type siteAlias struct {
+	alias     string
+	canonical string
+}
+
+func NewSiteRegistry() *SiteRegistry {
+	return &SiteRegistry{}
+}
+
+// RegisterHost pattern 可以是 "jable.tv"（同时匹配所有子域名）或 "*.jable.tv"
+func (sr *SiteRegistry) RegisterHost(name, pattern string, fn fetchVideoMetaFunc) {
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "*."))
+	sr.matchers = append(sr.matchers, &siteMatcher{
+		name:    name,
+		kind:    matchKindHost,
+		pattern: pattern,
+		fn:      fn,
+	})
+}
+
+// RegisterRegex 使用完整 URL 正则匹配
+func (sr *SiteRegistry) RegisterRegex(name, expr string, fn fetchVideoMetaFunc) error {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid url regex %q: %w", expr, err)
+	}
+	sr.matchers = append(sr.matchers, &siteMatcher{
+		name:    name,
+		kind:    matchKindRegex,
+		pattern: expr,
+		reg:     reg,
+		fn:      fn,
+	})
+	return nil
+}
+
+// RegisterAlias 将镜像域名视为 canonical，匹配时按 canonical 查找 handler，页面地址不变
+func (sr *SiteRegistry) RegisterAlias(alias, canonical string) {
+	alias, canonical = strings.ToLower(alias), strings.ToLower(canonical)
+	sr.aliases = slices.DeleteFunc(sr.aliases, func(a siteAlias) bool { return a.alias == alias })
+	i, _ := slices.BinarySearchFunc(sr.aliases, len(alias), func(a siteAlias, n int) int { return n - len(a.alias) })
+	sr.aliases = slices.Insert(sr.aliases, i, siteAlias{alias: alias, canonical: canonical})
+}
+
+// Match 返回第一个匹配的 handler 及原因
+func (sr *SiteRegistry) Match(rawURL string) (*SiteMatch, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false
+	}
+	host := strings.ToLower(u.Hostname())
+	canonical, aliased := sr.resolveAlias(host)
+	for i := len(sr.matchers) - 1; i >= 0; i-- {
+		m := sr.matchers[i]
+		reason := ""
+		switch m.kind {
+		case matchKindHost:
+			if canonical == m.pattern {
+				reason = fmt.Sprintf("host %s equals %s", canonical, m.pattern)
+			} else if strings.HasSuffix(canonical, "."+m.pattern) {
+				reason = fmt.Sprintf("host %s is a subdomain of %s", canonical, m.pattern)
+			}
+		case matchKindRegex:
+			if m.reg.MatchString(rawURL) {
+				reason = fmt.Sprintf("url matches regex %s", m.pattern)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		if aliased && m.kind == matchKindHost {
+			reason = fmt.Sprintf("%s is an alias of %s, %s", host, canonical, reason)
+		}
+		return &SiteMatch{Name: m.name, Kind: m.kind, Pattern: m.pattern, Reason: reason, fn: m.fn}, true
+	}
+	return nil, false
+}
+
+// resolveAlias 镜像域名及其子域名都映射到规范域名，多个别名都匹配时使用最长的
+func (sr *SiteRegistry) resolveAlias(host string) (string, bool) {
+	for _, a := range sr.aliases {
+		if host == a.alias {
+			return a.canonical, true
+		}
+		if sub, ok := strings.CutSuffix(host, "."+a.alias); ok {
+			return sub + "." + a.canonical, true
+		}
+	}
+	return host, false
+}
+
+// Print 按匹配优先级输出所有 matcher 和别名
+func (sr *SiteRegistry) Print(w io.Writer) {
+	fmt.Fprintln(w, "Registered sites (highest priority first):")
+	for i := len(sr.matchers) - 1; i >= 0; i-- {
+		m := sr.matchers[i]
+		fmt.Fprintf(w, "  %-6s %-40s %s\n", m.kind, m.pattern, m.name)
+	}
+	if len(sr.aliases) > 0 {
+		fmt.Fprintln(w, "Aliases:")
+		for _, a := range sr.aliases {
+			fmt.Fprintf(w, "  %s -> %s\n", a.alias, a.canonical)
+		}
+	}
+}
+
+// funcName 返回函数名，用作内置 handler 的名称
+func funcName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return strings.TrimPrefix(name, "main.")
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestResolveAliasPrefersLongestAlias(t *testing.T) {
+	sr := NewSiteRegistry()
+	sr.RegisterAlias("example.com", "a.test")
+	sr.RegisterAlias("cdn.example.com", "b.test")
+	sr.RegisterAlias("Other.com", "c.test")
+	tests := map[string]string{
+		"example.com":         "a.test",
+		"www.example.com":     "www.a.test",
+		"cdn.example.com":     "b.test",
+		"img.cdn.example.com": "img.b.test",
+		"other.com":           "c.test",
+		"unrelated.com":       "unrelated.com",
+	}
+	for host, want := range tests {
+		if got, _ := sr.resolveAlias(host); got != want {
+			t.Errorf("resolveAlias(%q) = %q, want %q", host, got, want)
+		}
+	}
+	sr.RegisterAlias("example.com", "d.test")
+	if got, _ := sr.resolveAlias("example.com"); got != "d.test" || len(sr.aliases) != 3 {
+		t.Errorf("re-registered alias resolves to %q with %d aliases", got, len(sr.aliases))
+	}
+}
@@ -7,27 +7,51 @@ import (
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
-	"log"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"regexp"
-	"strings"
 	"time"
 )
 
+// CAPTURE_TIMEOUT 页面就绪后等待可播放 playlist 的默认超时时间
+const CAPTURE_TIMEOUT = 30 * time.Second
+
 // FetchVideoMeta
 // metaName -> <meta name={metaName} content="..."/>
-func FetchVideoMeta(videoURL string, metaName string, fn func(ctx context.Context, shtml *string) []chromedp.Action) *VideoMeta {
-	fmt.Printf("Open Chromedp, Fetching video metadata for URL: %s\n", videoURL)
+func FetchVideoMeta(bp *BrowserPool, videoURL string, metaName string, opts *CaptureOptions) (*VideoMeta, error) {
+	slog.Info("Fetching video metadata with chromedp", "url", videoURL)
 
-	// Step 1: 创建 chromedp 无头浏览器上下文
-	ctx, cancel := createContextWithUA()
-	defer cancel()
+	// Step 1: 从浏览器池中获取标签页
+	pageURL, err := url.Parse(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	proxyURL, err := globalProxyConfig.Resolve(proxyScopeMeta, pageURL, pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid proxy: %w", ErrMetadataFetch, err)
+	}
+	ctx, release, err := bp.Acquire(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: acquire browser: %w", ErrMetadataFetch, err)
+	}
+	defer func() {
+		// 释放前标签页上下文已结束，说明标签页或浏览器崩溃，回收该实例
+		release(ctx.Err() != nil)
+	}()
+	if err := enableProxyAuth(ctx, proxyURL); err != nil {
+		slog.Warn("Failed to enable proxy auth", "url", videoURL, "error", err)
+	}
 
-	// Step 2: 打开页面并执行 JS 提取 m3u8 链接
+	// Step 2: 打开页面，监听网络请求，第一个可播放的 playlist 出现时立即返回
 	var m3u8URL, shtml, title string
 	var commonTitle string
 	var commonTitleEval = "document.title"
-	m3u8URLCh := make(chan string, 1)
+	var filter *regexp.Regexp
+	if opts != nil {
+		filter = opts.NetworkFilter
+	}
+	capture := newPlaylistCapture(filter)
 
 	_ = chromedp.Run(ctx,
 		network.Enable(),
@@ -35,31 +59,54 @@ func FetchVideoMeta(videoURL string, metaName string, fn func(ctx context.Contex
 		target.SetDiscoverTargets(true),
 	)
 
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		if ev, ok := ev.(*network.EventRequestWillBeSent); ok {
-			targetURL := ev.Request.URL
-			if strings.Contains(targetURL, ".m3u8") && m3u8URL == "" {
-				m3u8URLCh <- targetURL
-			}
-		}
-	})
-	err := chromedp.Run(ctx,
+	capture.Listen(ctx)
+	if onBrowserTab != nil {
+		// 在 release 之前执行，标签页关闭前完成录制
+		defer onBrowserTab(ctx)()
+	}
+	err = chromedp.Run(ctx,
 		chromedp.Navigate(videoURL),
 		chromedp.WaitReady("body", chromedp.ByQuery),
-		chromedp.Sleep(10*time.Second), // 等待 JS 加载
-		chromedp.OuterHTML(`html`, &shtml),
-		chromedp.Evaluate(commonTitleEval, &commonTitle),
-		//chromedp.Evaluate(m3u8Eval, &m3u8URL),
 	)
-	if fn != nil {
-		actions := make([]chromedp.Action, 0)
-		actions = append(actions, fn(ctx, &shtml)...)
-		err = chromedp.Run(ctx, actions...)
+	if err == nil && opts != nil && len(opts.Actions) > 0 {
+		err = chromedp.Run(ctx, opts.Actions...)
 	}
 	if err != nil && !errors.Is(err, context.Canceled) {
-		fmt.Printf("Failed to fetch video metadata for URL %s: %v\n", videoURL, err)
+		slog.Warn("Failed to load page", "url", videoURL, "error", err)
 		//return nil
 	}
+
+	timeout := CAPTURE_TIMEOUT
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	// 大部分 CDN 校验的是播放页面而不是 m3u8 所在的 host
+	origin, _ := getHost(videoURL, "v2")
+	headers := http.Header{
+		"Referer": {videoURL},
+		"Origin":  {origin},
+	}
+	var cookies []*http.Cookie
+	select {
+	case playlist := <-capture.Playable():
+		m3u8URL = playlist.URL
+		// 回放浏览器请求 m3u8 时的请求头和 cookie
+		for k, vs := range playlist.Headers {
+			headers[k] = vs
+		}
+		cookies, err = browserCookies(ctx, m3u8URL)
+		if err != nil {
+			slog.Warn("Failed to get cookies", "url", videoURL, "m3u8", m3u8URL, "error", err)
+		}
+	case <-time.After(timeout):
+		slog.Warn("Timed out waiting for m3u8", "url", videoURL, "timeout", timeout)
+	}
+
+	// 此时页面已经加载完播放器，标题一般也已就绪
+	_ = chromedp.Run(ctx,
+		chromedp.OuterHTML(`html`, &shtml),
+		chromedp.Evaluate(commonTitleEval, &commonTitle),
+	)
 	reg, _ := regexp.Compile(`<meta[^>]+name=["']` + metaName + `["'][^>]+content=["']([^"']+)["']`)
 	matches := reg.FindStringSubmatch(shtml)
 	if len(matches) > 0 {
@@ -70,70 +117,137 @@ func FetchVideoMeta(videoURL string, metaName string, fn func(ctx context.Contex
 		title = commonTitle
 	}
 
-	select {
-	case m3u8URL = <-m3u8URLCh:
-	case <-time.After(30 * time.Second):
-		fmt.Println("⚠️ 超时未捕获 m3u8")
-		cancel()
-	}
-	//if m3u8URL == "" {
-	//	reg, _ := regexp.Compile(`https:\/\/[^\s'"]+\.m3u8[^\s'"]*`)
-	//	matches := reg.FindStringSubmatch(shtml)
-	//	if len(matches) > 0 {
-	//		m3u8URL = matches[1]
-	//	}
-	//}
-	fmt.Printf("Fetched metadata - Title: %s, M3U8 URL: %s\n", title, m3u8URL)
-	return &VideoMeta{
-		URL:     videoURL,
-		VideoID: hash(videoURL),
-		Title:   title,
-		M3u8URL: m3u8URL,
+	candidates := capture.Candidates()
+	for _, c := range candidates {
+		slog.Debug("Captured playlist", "url", videoURL, "kind", c.Kind, "playlist", c.URL)
+	}
+	slog.Info("Fetched metadata", "url", videoURL, "title", title, "m3u8", m3u8URL)
+	videoMeta := &VideoMeta{
+		URL:       videoURL,
+		VideoID:   hash(videoURL),
+		Title:     title,
+		M3u8URL:   m3u8URL,
+		Headers:   headers,
+		Cookies:   cookies,
+		Playlists: candidates,
+	}
+	var details *DetailRule
+	if opts != nil {
+		details = opts.Details
 	}
+	(&Page{URL: videoURL, Body: shtml}).ExtractDetails(videoMeta, details)
+	return videoMeta, nil
 }
 
-func NormalFetchVideoMeta(videoURL string, metaName string) *VideoMeta {
-	return FetchVideoMeta(videoURL, metaName, nil)
+// NormalFetchVideoMeta 先尝试在页面源码中查找 m3u8 链接，找不到时再启动浏览器
+func NormalFetchVideoMeta(bp *BrowserPool, videoURL string, metaName string) (*VideoMeta, error) {
+	return HTTPFirstFetchVideoMeta(bp, videoURL, metaName, &HTTPExtractRule{TitleMeta: metaName}, nil)
 }
 
-func FetchJableTVVideoMeta(videoURL string) *VideoMeta {
-	return NormalFetchVideoMeta(videoURL, "og:title")
+// HTTPFirstFetchVideoMeta 优先按 rule 使用纯 HTTP 提取，失败时回退到 chromedp
+func HTTPFirstFetchVideoMeta(bp *BrowserPool, videoURL string, metaName string, rule *HTTPExtractRule, opts *CaptureOptions) (*VideoMeta, error) {
+	if forceBrowserExtractor {
+		return FetchVideoMeta(bp, videoURL, metaName, opts)
+	}
+	videoMeta, err := FetchHTTPVideoMeta(videoURL, rule)
+	if err == nil {
+		return videoMeta, nil
+	}
+	slog.Info("HTTP extractor failed, falling back to chromedp", "url", videoURL, "error", err)
+	return FetchVideoMeta(bp, videoURL, metaName, opts)
+}
+
+func FetchJableTVVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
+	// var hlsUrl = 'https://xxx/xxx.m3u8';
+	details := &DetailRule{
+		Actors: ".models .model img@title, .models .model span",
+		Tags:   "h5.tags a",
+	}
+	return HTTPFirstFetchVideoMeta(bp, videoURL, "og:title", &HTTPExtractRule{
+		TitleMeta: "og:title",
+		M3u8Regex: `hlsUrl\s*=\s*['"]([^'"]+)['"]`,
+		Details:   details,
+	}, &CaptureOptions{Details: details})
 }
 
-func FetchHohojTVVideoMeta(videoURL string) *VideoMeta {
+func FetchHohojTVVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
 	// iframe
-	// todo: hohoj.tv可以改为http请求
-	u, _ := url.Parse(videoURL)
-	videoID := u.Query()["id"]
-	embedVideoURL := fmt.Sprintf("https://hohoj.tv/embed?id=%s", videoID[0])
-	//return NormalFetchVideoMeta(embedVideoURL, "description", "videoSrc")
-	return NormalFetchVideoMeta(embedVideoURL, "description")
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	videoID := u.Query().Get("id")
+	if videoID == "" {
+		return nil, fmt.Errorf("%w: no id in %s", ErrInvalidURL, videoURL)
+	}
+	embedVideoURL := fmt.Sprintf("https://hohoj.tv/embed?id=%s", videoID)
+	// embed 页面中的 videoSrc 即为 m3u8 地址
+	return HTTPFirstFetchVideoMeta(bp, embedVideoURL, "description", &HTTPExtractRule{
+		TitleMeta: "description",
+		M3u8Regex: `videoSrc\s*[:=]\s*['"]([^'"]+)['"]`,
+	}, nil)
 }
 
-func FetchMissavAiVideoMeta(videoURL string) *VideoMeta {
+func FetchMissavAiVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
 	// 二级m3u8文件 playlist.m3u8 -> master.m3u8
 	//return NormalFetchVideoMeta(videoURL, "twitter:title", "hls.url")
-	return NormalFetchVideoMeta(videoURL, "twitter:title")
+	// 播放器懒加载，点击播放按钮后才会请求 playlist
+	return FetchVideoMeta(bp, videoURL, "twitter:title", &CaptureOptions{
+		Actions: []chromedp.Action{ClickIfPresent(".plyr__control--overlaid")},
+		Details: &DetailRule{
+			Cover:       `meta[property="og:image"], video@data-poster`,
+			Actors:      `a[href*="/actresses/"]`,
+			Tags:        `a[href*="/genres/"]`,
+			Studio:      `a[href*="/makers/"]`,
+			ReleaseDate: "time",
+		},
+	})
 }
 
-func FetchMemojavVideoMeta(videoURL string) *VideoMeta {
+func FetchMemojavVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
 	// get_video_info.php找到m3u8链接
 	// init.mp4 + .m4s
 	// eg. https://memojav.com/hls/get_video_info.php?id=DLDSS-414&sig=MjU2OTE0Nw&sts=6287002
-	return NormalFetchVideoMeta(videoURL, "twitter:title")
+	return HTTPFirstFetchVideoMeta(bp, videoURL, "twitter:title", &HTTPExtractRule{
+		TitleMeta:   "twitter:title",
+		FollowRegex: `['"]([^'"]*get_video_info\.php[^'"]*)['"]`,
+	}, nil)
 }
 
-func FetchAVTodayIOVideoMeta(videoURL string) *VideoMeta {
-	return NormalFetchVideoMeta(videoURL, "description")
+func FetchAVTodayIOVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
+	return NormalFetchVideoMeta(bp, videoURL, "description")
 }
 
-func FetchNetflAVVideoMeta(_ string) *VideoMeta {
+func FetchNetflAVVideoMeta(_ *BrowserPool, _ string) (*VideoMeta, error) {
 	// 而且很慢
-	log.Fatal("NetflAV not support(use iframe)! use raw m3u8")
-	return nil
+	return nil, fmt.Errorf("%w: netflav.com plays videos in an iframe, submit the m3u8 URL instead", ErrUnsupportedSite)
 	//return NormalFetchVideoMeta(videoURL, "description")
 }
 
-func FetchBzraizyVideoMeta(videoURL string) *VideoMeta {
-	return NormalFetchVideoMeta(videoURL, "og:title")
+func FetchBzraizyVideoMeta(bp *BrowserPool, videoURL string) (*VideoMeta, error) {
+	return NormalFetchVideoMeta(bp, videoURL, "og:title")
+}
+
+// RegisterBuiltinSites 注册内置站点及其镜像域名，selftest 要求每个内置站点都有录制结果
+func (dm *DLMaster) RegisterBuiltinSites() error {
+	builtinSites := []struct {
+		url string
+		fn  fetchVideoMetaFunc
+	}{
+		{"https://jable.tv/", FetchJableTVVideoMeta},
+		{"https://hohoj.tv/", FetchHohojTVVideoMeta},
+		{"https://missav.ai/", FetchMissavAiVideoMeta},
+		{"https://memojav.com/", FetchMemojavVideoMeta},
+		{"https://avtoday.io/", FetchAVTodayIOVideoMeta},
+		{"https://netflav.com/", FetchNetflAVVideoMeta},
+		{"https://f15.bzraizy.cc/", FetchBzraizyVideoMeta},
+	}
+	for _, site := range builtinSites {
+		if err := dm.RegisterVideoHandle(site.url, site.fn); err != nil {
+			return err
+		}
+	}
+	dm.RegisterHostAlias("missav.com", "missav.ai")
+	dm.RegisterHostAlias("missav.ws", "missav.ai")
+	return nil
 }
@@ -4,10 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -28,6 +29,9 @@ type TsWriter struct {
 	buffer       map[int][]byte
 	downloadChan chan TsData
 	quitCh       chan struct{}
+	doneCh       chan struct{} // 合并协程退出后关闭，未启动时为 nil
+	stopOnce     sync.Once
+	logger       *slog.Logger
 }
 
 func NewTsWriter(tsDir string) *TsWriter {
@@ -54,6 +58,7 @@ func NewTsWriter(tsDir string) *TsWriter {
 		segments:     segments,
 		downloadChan: make(chan TsData, 64),
 		quitCh:       make(chan struct{}),
+		logger:       slog.Default(),
 	}
 }
 
@@ -66,12 +71,20 @@ func (tw *TsWriter) StartMerge() {
 		lastTime   = time.Now()
 	)
 
+	bufferTs := func(ts TsData) {
+		tw.buffer[ts.Index] = ts.Data
+		totalSize += len(ts.Data)
+		metricTsBuffer.Add(float64(len(ts.Data)))
+	}
+
+	tw.doneCh = make(chan struct{})
 	go func() {
+		defer close(tw.doneCh)
+		defer mergeTimer.Stop()
 		for {
 			select {
 			case ts := <-tw.downloadChan:
-				tw.buffer[ts.Index] = ts.Data
-				totalSize += len(ts.Data)
+				bufferTs(ts)
 
 				if totalSize >= maxBufferSize && len(tw.buffer) > 1 {
 					tw.mergeBufferedTS(&tw.buffer, true)
@@ -86,7 +99,15 @@ func (tw *TsWriter) StartMerge() {
 					lastTime = time.Now()
 				}
 			case <-tw.quitCh:
-				return
+				// WriteTs 放入 downloadChan 就返回，退出前取出还在排队的分片，Persist/Flush 才不会漏掉
+				for {
+					select {
+					case ts := <-tw.downloadChan:
+						bufferTs(ts)
+					default:
+						return
+					}
+				}
 			}
 		}
 	}()
@@ -97,6 +118,7 @@ func (tw *TsWriter) mergeBufferedTS(buffer *map[int][]byte, skipSmallFile bool)
 	if oldSize == 0 {
 		return
 	}
+	defer metricTsMerge.ObserveSince(time.Now(), "buffer")
 	// 找连续段
 	indexes := make([]int, 0, len(*buffer))
 	for idx := range *buffer {
@@ -120,7 +142,7 @@ func (tw *TsWriter) mergeBufferedTS(buffer *map[int][]byte, skipSmallFile bool)
 	// 写入最后一段 [start, end)
 	tw.writeBufferToFile(start, end, buffer, skipSmallFile)
 	newSize := len(*buffer)
-	log.Printf("Merging TS %d files (from %d to %d)\n", oldSize-newSize, oldSize, newSize)
+	tw.logger.Debug("Merged buffered segments", "segments", oldSize-newSize, "buffered", newSize)
 }
 
 func (tw *TsWriter) writeBufferToFile(start, end int, buffer *map[int][]byte, skipSmallFile bool) {
@@ -134,6 +156,7 @@ func (tw *TsWriter) writeBufferToFile(start, end int, buffer *map[int][]byte, sk
 		data, _ := (*buffer)[i]
 		_, _ = f.Write(data)
 		delete(*buffer, i)
+		metricTsBuffer.Add(-float64(len(data)))
 	}
 	_ = f.Close()
 
@@ -144,6 +167,17 @@ func (tw *TsWriter) writeBufferToFile(start, end int, buffer *map[int][]byte, sk
 	tw.segments = append(tw.segments[:insertIndex], append([]MergeRange{{Start: start, End: end}}, tw.segments[insertIndex:]...)...)
 }
 
+// Size 已写入文件的分片大小，用于断点续传时统计已下载的字节数
+func (tw *TsWriter) Size() int64 {
+	var size int64
+	for _, seg := range tw.segments {
+		if fi, err := os.Stat(filepath.Join(tw.baseDir, fmt.Sprintf("%d_%d.ts", seg.Start, seg.End))); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
 func (tw *TsWriter) CheckTsIsExist(tsIndex int) bool {
 	_, ok := sort.Find(len(tw.segments), func(i int) int {
 		if tw.segments[i].End <= tsIndex {
@@ -161,9 +195,26 @@ func (tw *TsWriter) WriteTs(tsIndex int, data []byte) {
 	tw.downloadChan <- TsData{Index: tsIndex, Data: data}
 }
 
+// stopMerge 停止合并协程并等待其退出，可以重复调用（例如合并时被 Stop 后又调用 Persist）
+func (tw *TsWriter) stopMerge() {
+	tw.stopOnce.Do(func() {
+		close(tw.quitCh)
+		if tw.doneCh != nil {
+			<-tw.doneCh
+		}
+	})
+}
+
+// Persist 停止合并协程，缓冲中的分片全部写入文件，不生成 merge.ts
+func (tw *TsWriter) Persist() {
+	tw.stopMerge()
+	tw.mergeBufferedTS(&tw.buffer, false)
+}
+
 func (tw *TsWriter) Flush() string {
-	tw.quitCh <- struct{}{}
+	tw.stopMerge()
 	tw.mergeBufferedTS(&tw.buffer, false)
+	defer metricTsMerge.ObserveSince(time.Now(), "final")
 
 	mergeFilePath := filepath.Join(tw.baseDir, "merge.ts")
 	outMv, _ := os.Create(mergeFilePath)
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTsWriterFlushKeepsQueuedSegments(t *testing.T) {
+	for run := 0; run < 50; run++ {
+		tw := NewTsWriter(t.TempDir())
+		tw.StartMerge()
+		// WriteTs 只把分片放入队列，Flush 时合并协程可能还没取出
+		for i := 0; i < 60; i++ {
+			tw.WriteTs(i, []byte{byte(i)})
+		}
+		data, err := os.ReadFile(tw.Flush())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 60 {
+			t.Fatalf("run %d: merged %d segments, want 60", run, len(data))
+		}
+		for i, b := range data {
+			if int(b) != i {
+				t.Fatalf("run %d: byte %d = %d, segments out of order", run, i, b)
+			}
+		}
+	}
+}
@@ -5,27 +5,50 @@ import (
 	"context"
 	"fmt"
 	"github.com/chromedp/chromedp"
+	"log/slog"
+	"net/url"
 	"os"
 	"strings"
 )
 
-func createContextWithUA() (context.Context, context.CancelFunc) {
+// BROWSER_USER_AGENT 无头浏览器和纯 HTTP 提取页面时使用的 UA
+const BROWSER_USER_AGENT = `Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36 Edg/137.0.0.0`
+
+// createContextWithUA extra 为额外的 Chrome 启动参数
+func createContextWithUA(proxyURL *url.URL, extra ...chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc) {
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.UserAgent(`Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36 Edg/137.0.0.0`),
+		chromedp.UserAgent(BROWSER_USER_AGENT),
 		chromedp.Flag("disable-features", "site-per-process,Translate,BlinkGenPropertyTrees,IsolateOrigins,site-per-process"),
 	)
+	opts = append(opts, browserProxyOptions(proxyURL)...)
+	opts = append(opts, extra...)
 
-	allocCtx, _ := chromedp.NewExecAllocator(context.Background(), opts...)
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
 	ctx, cancel := chromedp.NewContext(allocCtx)
 
-	return ctx, cancel
+	return ctx, func() {
+		cancel()
+		allocCancel()
+	}
+}
+
+// stringsFlag 可重复指定的命令行参数
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
-func loadURLs(path string) []string {
+// loadURLs 读取地址列表，每行一个地址，重复和不以 http 开头的行会被跳过
+func loadURLs(path string) ([]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
-		return nil
+		return nil, err
 	}
 	defer f.Close()
 	scanner := bufio.NewScanner(f)
@@ -37,12 +60,11 @@ func loadURLs(path string) []string {
 			urls[url] = true
 			result = append(result, url)
 		} else {
-			fmt.Printf("Skipping invalid URL: %s\n", url)
+			slog.Warn("Skipping invalid URL", "url", url)
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading file:", err)
-		return nil
+		return nil, fmt.Errorf("read %s: %w", path, err)
 	}
-	return result
+	return result, nil
 }
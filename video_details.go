@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 番号，例如 SSIS-001、FC2-PPV-1234567
+var defaultCodeRegex = regexp.MustCompile(`(?i)\b((?:fc2-ppv|[a-z]{2,6})-?\d{2,7})\b`)
+
+// DetailRule 封面、演员、标签等信息的提取规则，所有字段可选，为空时依次使用 <meta>（og:image、video:actor 等）
+// 和 JSON-LD VideoObject 中的值。选择器可以用 "selector@attr" 指定取属性，否则取 content/src/文本
+type DetailRule struct {
+	Cover       string `json:"cover"`        // 封面图片
+	Description string `json:"description"`  // 简介
+	Actors      string `json:"actors"`       // 演员，匹配所有元素
+	Tags        string `json:"tags"`         // 标签，匹配所有元素
+	Studio      string `json:"studio"`       // 片商
+	ReleaseDate string `json:"release_date"` // 发行日期
+	Duration    string `json:"duration"`     // 时长，支持 ISO8601（PT1H2M）、HH:MM:SS、秒数
+	CodeRegex   string `json:"code_regex"`   // 从 URL 和标题中提取番号，第一个分组为结果
+}
+
+// ExtractDetails 从页面中提取封面、演员等信息填入 videoMeta，已有的值不会被覆盖
+func (p *Page) ExtractDetails(videoMeta *VideoMeta, rule *DetailRule) {
+	siteRule := rule != nil
+	if rule == nil {
+		rule = &DetailRule{}
+	}
+	ld := p.videoObject()
+
+	first := func(selector string, fallback ...string) string {
+		if selector != "" {
+			if values := p.selectValues(selector); len(values) > 0 {
+				return values[0]
+			}
+		}
+		for _, v := range fallback {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	all := func(selector string, fallback []string) []string {
+		if selector != "" {
+			if values := p.selectValues(selector); len(values) > 0 {
+				return values
+			}
+		}
+		return fallback
+	}
+
+	if videoMeta.Cover == "" {
+		cover := first(rule.Cover, p.Meta("og:image"), p.Meta("twitter:image"), ldString(ld["thumbnailUrl"]))
+		if cover != "" {
+			videoMeta.Cover = p.ResolveURL(cover)
+		}
+	}
+	if videoMeta.Description == "" {
+		videoMeta.Description = first(rule.Description, p.Meta("og:description"), p.Meta("description"), ldString(ld["description"]))
+	}
+	if len(videoMeta.Actors) == 0 {
+		videoMeta.Actors = uniqueStrings(all(rule.Actors, append(p.MetaAll("video:actor"), ldNames(ld["actor"])...)))
+	}
+	if len(videoMeta.Tags) == 0 {
+		tags := append(p.MetaAll("video:tag"), p.MetaAll("article:tag")...)
+		if len(tags) == 0 {
+			tags = ldKeywords(ld["keywords"])
+		}
+		videoMeta.Tags = uniqueStrings(all(rule.Tags, tags))
+	}
+	if videoMeta.Studio == "" {
+		studio := ""
+		if names := ldNames(ld["productionCompany"]); len(names) > 0 {
+			studio = names[0]
+		}
+		videoMeta.Studio = first(rule.Studio, studio)
+	}
+	if videoMeta.ReleaseDate == "" {
+		videoMeta.ReleaseDate = normalizeDate(first(rule.ReleaseDate, p.Meta("video:release_date"), ldString(ld["uploadDate"])))
+	}
+	if videoMeta.Duration == 0 {
+		videoMeta.Duration = parseVideoDuration(first(rule.Duration, p.Meta("video:duration"), ldString(ld["duration"])))
+	}
+	if videoMeta.Code == "" {
+		videoMeta.Code = findVideoCode(rule.CodeRegex, videoMeta.URL, videoMeta.Title)
+		videoMeta.SiteCode = siteRule && videoMeta.Code != ""
+	}
+}
+
+// selectValues 逗号分隔的每个选择器都可以带 "@attr" 后缀，未指定时 meta 取 content，
+// img/source 取 data-src/src，video 取 poster，其余取文本
+func (p *Page) selectValues(selector string) []string {
+	var result []string
+	for _, group := range strings.Split(selector, ",") {
+		result = append(result, p.selectGroupValues(strings.TrimSpace(group))...)
+	}
+	return result
+}
+
+func (p *Page) selectGroupValues(selector string) []string {
+	attr := ""
+	if i := strings.LastIndex(selector, "@"); i >= 0 {
+		selector, attr = selector[:i], selector[i+1:]
+	}
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		slog.Error("Invalid detail selector", "selector", selector, "error", err)
+		return nil
+	}
+	var result []string
+	for _, n := range sel.MatchAll(p.Doc()) {
+		v := ""
+		switch {
+		case attr != "":
+			v = nodeAttr(n, attr)
+		case n.Data == "meta":
+			v = nodeAttr(n, "content")
+		case n.Data == "img" || n.Data == "source":
+			if v = nodeAttr(n, "data-src"); v == "" {
+				v = nodeAttr(n, "src")
+			}
+		case n.Data == "video":
+			v = nodeAttr(n, "poster")
+		default:
+			v = nodeText(n)
+		}
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// MetaAll 返回所有同名 <meta> 的 content，例如多个 video:actor
+func (p *Page) MetaAll(name string) []string {
+	var result []string
+	for _, n := range mustSelector("meta").MatchAll(p.Doc()) {
+		if nodeAttr(n, "name") == name || nodeAttr(n, "property") == name {
+			if v := strings.TrimSpace(nodeAttr(n, "content")); v != "" {
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+// videoObject 返回页面 JSON-LD 中第一个 VideoObject，没有时返回空 map
+func (p *Page) videoObject() map[string]interface{} {
+	for _, n := range mustSelector(`script[type="application/ld+json"]`).MatchAll(p.Doc()) {
+		if n.FirstChild == nil {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(n.FirstChild.Data), &v); err != nil {
+			continue
+		}
+		if obj := findVideoObject(v); obj != nil {
+			return obj
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func findVideoObject(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if t["@type"] == "VideoObject" {
+			return t
+		}
+		return findVideoObject(t["@graph"])
+	case []interface{}:
+		for _, child := range t {
+			if obj := findVideoObject(child); obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// ldString JSON-LD 中的值可能是字符串或字符串数组
+func ldString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case []interface{}:
+		if len(t) > 0 {
+			return ldString(t[0])
+		}
+	}
+	return ""
+}
+
+// ldNames actor/productionCompany 可能是 {"name": ...}、字符串或它们的数组
+func ldNames(v interface{}) []string {
+	var result []string
+	switch t := v.(type) {
+	case string:
+		result = append(result, strings.TrimSpace(t))
+	case map[string]interface{}:
+		if name := ldString(t["name"]); name != "" {
+			result = append(result, name)
+		}
+	case []interface{}:
+		for _, child := range t {
+			result = append(result, ldNames(child)...)
+		}
+	}
+	return result
+}
+
+func ldKeywords(v interface{}) []string {
+	if s, ok := v.(string); ok {
+		var result []string
+		for _, kw := range strings.Split(s, ",") {
+			if kw = strings.TrimSpace(kw); kw != "" {
+				result = append(result, kw)
+			}
+		}
+		return result
+	}
+	return ldNames(v)
+}
+
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	result := list[:0]
+	for _, s := range list {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	return result
+}
+
+var (
+	isoDurationRegex     = regexp.MustCompile(`^P(?:(\d+)D)?T?(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+	minutesDurationRegex = regexp.MustCompile(`(\d+)\s*(分钟|分鐘|min)`)
+)
+
+// parseVideoDuration 支持 ISO8601（PT1H2M3S）、HH:MM:SS、MM:SS、秒数和 "120 分钟"、"120 min"
+func parseVideoDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	if m := isoDurationRegex.FindStringSubmatch(strings.ToUpper(s)); m != nil {
+		var d time.Duration
+		for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute} {
+			if n, err := strconv.Atoi(m[i+1]); err == nil {
+				d += time.Duration(n) * unit
+			}
+		}
+		if sec, err := strconv.ParseFloat(m[4], 64); err == nil {
+			d += time.Duration(sec * float64(time.Second))
+		}
+		return d
+	}
+	if strings.Contains(s, ":") {
+		var d time.Duration
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return 0
+			}
+			d = d*60 + time.Duration(n)
+		}
+		return d * time.Second
+	}
+	if m := minutesDurationRegex.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return time.Duration(n) * time.Minute
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second))
+	}
+	return 0
+}
+
+// normalizeDate 尽量转换为 2006-01-02，无法识别时原样返回
+func normalizeDate(s string) string {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "2006/01/02", "2006.01.02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	if len(s) > 10 {
+		if t, err := time.Parse("2006-01-02", s[:10]); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return s
+}
+
+// findVideoCode 依次在 URL 路径和标题中查找番号，结果统一为大写
+func findVideoCode(expr, videoURL, title string) string {
+	reg := defaultCodeRegex
+	if expr != "" {
+		var err error
+		if reg, err = regexp.Compile(expr); err != nil {
+			slog.Error("Invalid code_regex", "regex", expr, "error", err)
+			return ""
+		}
+	}
+	sources := []string{title}
+	if u, err := url.Parse(videoURL); err == nil {
+		sources = append([]string{u.Path, u.RawQuery}, sources...)
+	}
+	for _, src := range sources {
+		if m := reg.FindStringSubmatch(src); len(m) > 1 {
+			return strings.ToUpper(m[1])
+		}
+	}
+	return ""
+}
+
+// fetchCover 下载封面，返回图片内容和扩展名，没有封面时返回 nil
+func (md *M3u8Downloader) fetchCover() ([]byte, string) {
+	cover := md.videoMeta.Cover
+	if cover == "" {
+		return nil, ""
+	}
+	ext := ".jpg"
+	if u, err := url.Parse(cover); err == nil {
+		if e := strings.ToLower(path.Ext(u.Path)); e == ".png" || e == ".webp" {
+			ext = e
+		}
+	}
+	ro := &HttpOptions{Headers: md.ro.Headers.Clone(), Site: md.ro.Site, Jar: md.ro.Jar}
+	ro.Headers.Set("Accept", "image/*,*/*")
+	status, data, err := httpGetBytes(cover, ro)
+	if err == nil && status != 200 {
+		err = fmt.Errorf("status code %d", status)
+	}
+	if err != nil {
+		md.log(slog.LevelError, "Failed to download cover", "url", cover, "error", err)
+		return nil, ""
+	}
+	return data, ext
+}
+
+// saveCover 将封面保存到视频旁边，文件名与视频相同
+func (md *M3u8Downloader) saveCover(data []byte, ext string) {
+	if data == nil {
+		return
+	}
+	coverName := md.basePath() + ext
+	if err := os.WriteFile(coverName, data, 0644); err != nil {
+		md.log(slog.LevelError, "Failed to save cover", "path", coverName, "error", err)
+		return
+	}
+	md.log(slog.LevelInfo, "Cover saved", "path", coverName)
+}
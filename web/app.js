@@ -0,0 +1,164 @@
+"use strict";
+
+// 每个状态可以执行的操作，与 serve.go 中的状态检查一致
+const ACTIONS = {
+  "pending": ["pause", "cancel"],
+  "fetching-meta": ["pause", "cancel"],
+  "downloading": ["pause", "cancel"],
+  "merging": [],
+  "paused": ["resume", "cancel"],
+  "failed": ["retry", "cancel", "delete"],
+  "canceled": ["retry", "delete"],
+  "done": ["delete"],
+};
+
+const jobs = new Map();
+const list = document.getElementById("jobs");
+const template = document.getElementById("job-template");
+
+function showMessage(text) {
+  const el = document.getElementById("message");
+  el.textContent = text;
+  el.hidden = !text;
+}
+
+async function request(method, path, body) {
+  const res = await fetch(path, {
+    method: method,
+    // 修改类接口要求 JSON Content-Type，其他网站的表单无法伪造
+    headers: method === "GET" ? {} : { "Content-Type": "application/json" },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (!res.ok) {
+    let msg = res.statusText;
+    try {
+      msg = (await res.json()).error || msg;
+    } catch (e) {}
+    throw new Error(msg);
+  }
+  return res;
+}
+
+function render(job) {
+  let el = document.getElementById("job-" + job.id);
+  if (!el) {
+    el = template.content.firstElementChild.cloneNode(true);
+    el.id = "job-" + job.id;
+    el.querySelectorAll("button").forEach((btn) => {
+      btn.addEventListener("click", () => act(job.id, btn.dataset.action, el));
+    });
+    list.prepend(el);
+  }
+  const title = job.title || job.url;
+  el.querySelector(".name").textContent = title;
+  const state = el.querySelector(".state");
+  state.textContent = job.state;
+  state.className = "state " + job.state;
+  el.querySelector(".url").textContent = job.url;
+  el.querySelector("progress").value = job.progress || 0;
+  el.querySelector(".detail").textContent = job.segments > 0
+    ? job.completed + " / " + job.segments + " segments (" + Math.floor((job.progress || 0) * 100) + "%)"
+    : "";
+  el.querySelector(".error").textContent = job.state === "failed" && job.error ? job.error : "";
+  el.querySelector(".output").textContent = job.output ? "File: " + job.output : "";
+  const allowed = ACTIONS[job.state] || [];
+  el.querySelectorAll("button").forEach((btn) => {
+    btn.hidden = btn.dataset.action !== "logs" && !allowed.includes(btn.dataset.action);
+  });
+}
+
+function remove(id) {
+  jobs.delete(id);
+  const el = document.getElementById("job-" + id);
+  if (el) {
+    el.remove();
+  }
+  updateEmpty();
+}
+
+function updateEmpty() {
+  document.getElementById("empty").hidden = jobs.size > 0;
+}
+
+async function act(id, action, el) {
+  showMessage("");
+  try {
+    if (action === "logs") {
+      const pre = el.querySelector(".logs");
+      if (!pre.hidden) {
+        pre.hidden = true;
+        return;
+      }
+      const res = await request("GET", "api/jobs/" + id + "/logs?tail=200");
+      pre.textContent = await res.text();
+      pre.hidden = false;
+      pre.scrollTop = pre.scrollHeight;
+      return;
+    }
+    if (action === "delete") {
+      await request("DELETE", "api/jobs/" + id);
+      remove(id);
+      return;
+    }
+    await request("POST", "api/jobs/" + id + "/" + action);
+  } catch (e) {
+    showMessage(e.message);
+  }
+}
+
+document.getElementById("add").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  showMessage("");
+  const url = document.getElementById("url");
+  const title = document.getElementById("title");
+  try {
+    const res = await request("POST", "api/jobs", { url: url.value.trim(), title: title.value.trim() });
+    const job = await res.json();
+    jobs.set(job.id, job);
+    render(job);
+    updateEmpty();
+    url.value = "";
+    title.value = "";
+  } catch (e) {
+    showMessage(e.message);
+  }
+});
+
+function connect() {
+  const status = document.getElementById("status");
+  const source = new EventSource("api/events");
+  source.onopen = () => {
+    status.textContent = "online";
+    status.className = "status online";
+  };
+  source.onerror = () => {
+    status.textContent = "offline";
+    status.className = "status offline";
+  };
+  // 连接（包括自动重连）后先收到完整的任务列表
+  source.addEventListener("jobs", (ev) => {
+    const all = JSON.parse(ev.data);
+    const ids = new Set(all.map((job) => job.id));
+    for (const id of Array.from(jobs.keys())) {
+      if (!ids.has(id)) {
+        remove(id);
+      }
+    }
+    for (const job of all) {
+      jobs.set(job.id, job);
+      render(job);
+    }
+    updateEmpty();
+  });
+  source.addEventListener("job", (ev) => {
+    const job = JSON.parse(ev.data);
+    jobs.set(job.id, job);
+    render(job);
+    updateEmpty();
+  });
+  source.addEventListener("delete", (ev) => {
+    remove(JSON.parse(ev.data).id);
+  });
+}
+
+connect();
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// SSE_FLUSH_INTERVAL 推送任务更新的间隔，间隔内同一任务的多次更新只推送最后一次
+	SSE_FLUSH_INTERVAL = 500 * time.Millisecond
+	// SSE_PING_INTERVAL 没有更新时发送注释行，避免代理断开空闲连接
+	SSE_PING_INTERVAL = 15 * time.Second
+)
+
+//go:embed web
+var webFS embed.FS
+
+// jobEvent 推送给网页的任务更新，Job 为 nil 时表示任务已删除
+type jobEvent struct {
+	ID  string
+	Job *Job
+}
+
+// jobEvents 任务更新的广播，数据库写入和分片进度都会推送，分片进度不写数据库
+type jobEvents struct {
+	mu   sync.Mutex
+	last map[string]Job // 最近一次的任务状态，分片进度在此基础上修改
+	subs map[*jobSubscriber]bool
+}
+
+// jobSubscriber 只保留每个任务最新的状态，客户端处理慢时不会堆积
+type jobSubscriber struct {
+	mu      sync.Mutex
+	pending map[string]jobEvent
+}
+
+func newJobEvents() *jobEvents {
+	return &jobEvents{last: make(map[string]Job), subs: make(map[*jobSubscriber]bool)}
+}
+
+func (e *jobEvents) Subscribe() *jobSubscriber {
+	sub := &jobSubscriber{pending: make(map[string]jobEvent)}
+	e.mu.Lock()
+	e.subs[sub] = true
+	e.mu.Unlock()
+	return sub
+}
+
+func (e *jobEvents) Unsubscribe(sub *jobSubscriber) {
+	e.mu.Lock()
+	delete(e.subs, sub)
+	e.mu.Unlock()
+}
+
+// Publish 任务写入数据库后调用
+func (e *jobEvents) Publish(job *Job) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last[job.ID] = *job
+	e.broadcast(job.ID, job)
+}
+
+// Progress 每个分片下载完成后调用，任务不在 last 中时忽略
+func (e *jobEvents) Progress(id string, completed, total int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job, ok := e.last[id]
+	if !ok {
+		return
+	}
+	job.Completed, job.Segments = completed, total
+	e.last[id] = job
+	e.broadcast(id, &job)
+}
+
+// Deleted 任务删除后调用
+func (e *jobEvents) Deleted(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.last, id)
+	e.broadcast(id, nil)
+}
+
+func (e *jobEvents) broadcast(id string, job *Job) {
+	for sub := range e.subs {
+		var copied *Job
+		if job != nil {
+			j := *job
+			copied = &j
+		}
+		sub.mu.Lock()
+		sub.pending[id] = jobEvent{ID: id, Job: copied}
+		sub.mu.Unlock()
+	}
+}
+
+func (sub *jobSubscriber) drain() []jobEvent {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	events := make([]jobEvent, 0, len(sub.pending))
+	for id, ev := range sub.pending {
+		events = append(events, ev)
+		delete(sub.pending, id)
+	}
+	return events
+}
+
+// handleEvents GET /api/events，先发送所有任务（jobs），之后推送单个任务的更新（job）和删除（delete）
+func (dm *DLMaster) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	if dm.store == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, ErrNoJobStore)
+		return
+	}
+	// 先订阅再读取列表，期间的更新不会丢失
+	sub := dm.events.Subscribe()
+	defer dm.events.Unsubscribe(sub)
+	jobs, err := dm.store.List()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	list := make([]apiJob, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, newAPIJob(job))
+	}
+	if err := writeSSE(w, "jobs", list); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	flush := time.NewTicker(SSE_FLUSH_INTERVAL)
+	defer flush.Stop()
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-flush.C:
+		}
+		events := sub.drain()
+		if len(events) == 0 {
+			if time.Since(lastWrite) < SSE_PING_INTERVAL {
+				continue
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		for _, ev := range events {
+			if ev.Job == nil {
+				err = writeSSE(w, "delete", map[string]string{"id": ev.ID})
+			} else {
+				err = writeSSE(w, "job", newAPIJob(ev.Job))
+			}
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		lastWrite = time.Now()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// webHandler 内嵌的网页
+func webHandler() http.Handler {
+	sub, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}